@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"go/build"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/mzki/feserver/server"
 )
@@ -29,10 +35,51 @@ func main() {
 		conf = &server.DefaultConfig
 	}
 
-	// launch server process.
-	if err := server.ListenAndServe(conf); err != nil {
-		log.Fatalf("FATAL: %v", err)
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+		// launch server process.
+		if err := serve(conf); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+	case "mirror":
+		if err := runMirror(conf, flag.Args()[1:]); err != nil {
+			log.Fatalf("FATAL: %v", err)
+		}
+	default:
+		log.Fatalf("FATAL: unknown command %q", cmd)
+	}
+}
+
+// it runs the server until SIGINT or SIGTERM is received,
+// and then shuts down the server gracefully.
+func serve(conf *server.Config) error {
+	s := server.New(conf)
+
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		log.Printf("shutting down by %v", <-sig)
+		signal.Stop(sig)
+
+		ctx := context.Background()
+		if ss := conf.ShutdownSecond; ss > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(ss)*time.Second)
+			defer cancel()
+		}
+		done <- s.Shutdown(ctx)
+	}()
+
+	if err := s.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	// wait for the active requests.
+	if err := <-done; err != nil {
+		return fmt.Errorf("shutdown: %v", err)
 	}
+	log.Println("server stopped")
+	return nil
 }
 
 func loadConfig(confPath string) (*server.Config, error) {
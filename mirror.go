@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/mzki/feserver/server"
+	"github.com/mzki/feserver/src"
+)
+
+// runMirror runs mirror subcommand, which stores the questions
+// of the source into the local directory.
+//
+// Usage:
+//
+//	feserver [-config file] mirror [-sub addr] [-dir dir]
+func runMirror(conf *server.Config, args []string) error {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	subAddr := fs.String("sub", "", "sub address of the source to be mirrored")
+	dir := fs.String("dir", "mirror", "output directory")
+	fs.Parse(args)
+
+	var source *server.Source
+	for i, s := range conf.Sources {
+		if s.SubAddr == *subAddr {
+			source = &conf.Sources[i]
+			break
+		}
+	}
+	if source == nil {
+		return fmt.Errorf("mirror: source for sub address %q is not found in config", *subAddr)
+	}
+
+	m := &src.Mirror{
+		Getter: src.NewGetter(source.Source, src.LeastIntervalTime, conf.GetterOptions()...),
+		Dir:    *dir,
+		Progress: func(q src.Query, err error) {
+			if err != nil {
+				log.Printf("mirror: failed %s: %v", src.LocalPath(q), err)
+			} else {
+				log.Printf("mirror: saved %s", src.LocalPath(q))
+			}
+		},
+	}
+	report, err := m.Run(context.Background(), src.MaxQueryRange)
+	log.Printf("mirror: saved %d, skipped %d, failed %d questions into %s",
+		report.Saved, report.Skipped, report.Failed, *dir)
+	return err
+}
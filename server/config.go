@@ -1,10 +1,14 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/mzki/feserver/src"
@@ -14,10 +18,101 @@ import (
 // it must construct by LoadConfig() or LoadConfigFile().
 type Config struct {
 	// Http service address for the server.
+	// empty means the server listens on Listeners only,
+	// or on DefaultHTTP if Listeners is also empty.
 	HTTP string
+	// Additional addresses for the server, such as the TLS and the Unix domain socket.
+	Listeners []Listener
+	// Whether HTTP/2 is used for the TLS listeners.
+	// the other listeners always use HTTP/1.1.
+	HTTP2 bool
+	// Time for waiting the active requests at the shutdown, in second.
+	// zero means waiting until all of them are done.
+	ShutdownSecond int
 
 	// Source location for get questions.
 	Sources []Source
+
+	// Cache for the responses from the sources.
+	Cache Cache
+
+	// User-Agent for the requests to the sources.
+	// empty means the default of the Go http client.
+	UserAgent string
+	// Proxy server URL for the requests to the sources.
+	// empty means the proxy is determined by the environment variables.
+	Proxy string
+	// Timeout for a request to the sources, in second.
+	// zero means no timeout other than WaitSecond of the source.
+	TimeoutSecond int
+	// Additional headers for the requests to the sources.
+	Headers map[string]string
+	// Number of the other questions tried by the random APIs
+	// when the question can not be parsed. zero means no retry.
+	ParseRetry int
+
+	// File for saving the answer histories of the users in the quiz sessions.
+	// empty means the histories are kept in memory only.
+	ProgressFile string
+
+	// How the images in the questions are served.
+	// ImageURL | ImageProxy | ImageEmbed. empty means ImageURL.
+	Image string
+}
+
+// The modes for serving the images in the questions.
+const (
+	// the image locations are the source server as is.
+	ImageURL = "url"
+	// the image locations are rewritten to the images API of this server,
+	// which serves the images obtained from the source server.
+	ImageProxy = "proxy"
+	// the images are embedded into the response as data URI.
+	// it takes time to get the images unless they are cached.
+	ImageEmbed = "embed"
+)
+
+// Listener is the address for the server to listen on.
+type Listener struct {
+	// TCP address such as "localhost:8443", or the path of the Unix domain socket
+	// prefixed by "unix:", such as "unix:/run/feserver.sock".
+	Addr string
+	// Certificate and private key files in PEM for TLS.
+	// empty means plain HTTP. The files are reloaded when they are modified,
+	// so that the certificate can be renewed without restarting the server.
+	CertFile string
+	KeyFile  string
+}
+
+// it returns the network and the address for net.Listen.
+func (l Listener) network() (string, string) {
+	if path := strings.TrimPrefix(l.Addr, unixPrefix); path != l.Addr {
+		return "unix", path
+	}
+	return "tcp", l.Addr
+}
+
+// the prefix of Listener.Addr for the Unix domain socket.
+const unixPrefix = "unix:"
+
+// Cache is the configuration for caching the responses
+// from the sources. The cache is shared by all of the sources.
+type Cache struct {
+	// Maximum number of the responses in memory.
+	// zero means the in-memory cache is disabled.
+	Size int
+	// Directory for the on-disk cache.
+	// empty means the on-disk cache is disabled.
+	Dir string
+	// Maximum number of the responses in the directory.
+	// zero means no limitation.
+	DirSize int
+	// Maximum number of the images in memory, which are cached apart from
+	// the responses. zero means the images are not cached.
+	ImageSize int
+	// Life time for the cached response and image, in second.
+	// zero means the cached responses and images never expire.
+	TTLSecond int
 }
 
 func (conf *Config) validates() error {
@@ -30,6 +125,56 @@ func (conf *Config) validates() error {
 		if ws := s.WaitSecond; ws < 0 {
 			return fmt.Errorf("Config: incorrect WaitSecond %d, must be positive.", ws)
 		}
+		// check RefreshHour
+		if rh := s.RefreshHour; rh < 0 {
+			return fmt.Errorf("Config: incorrect RefreshHour %d, must be positive.", rh)
+		}
+		// check local dataset
+		if dir := s.Dir; dir != "" {
+			if _, err := os.Stat(dir); err != nil {
+				return fmt.Errorf("Config: incorrect Dir: %v", err)
+			}
+		}
+	}
+	// check listeners
+	for _, l := range conf.Listeners {
+		if network, addr := l.network(); addr == "" {
+			return fmt.Errorf("Config: incorrect Listeners, empty %s address.", network)
+		}
+		if (l.CertFile == "") != (l.KeyFile == "") {
+			return fmt.Errorf("Config: incorrect Listeners %s, both CertFile and KeyFile are required for TLS.", l.Addr)
+		}
+		if l.CertFile != "" {
+			if _, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile); err != nil {
+				return fmt.Errorf("Config: incorrect Listeners %s: %v", l.Addr, err)
+			}
+		}
+	}
+	// check image mode
+	switch conf.Image {
+	case "", ImageURL, ImageProxy, ImageEmbed:
+	default:
+		return fmt.Errorf("Config: Image must be either %s, %s or %s, but %s", ImageURL, ImageProxy, ImageEmbed, conf.Image)
+	}
+	// check http client
+	if conf.Proxy != "" {
+		if _, err := url.Parse(conf.Proxy); err != nil {
+			return fmt.Errorf("Config: incorrect Proxy: %v", err)
+		}
+	}
+	if ts := conf.TimeoutSecond; ts < 0 {
+		return fmt.Errorf("Config: incorrect TimeoutSecond %d, must be positive.", ts)
+	}
+	if ss := conf.ShutdownSecond; ss < 0 {
+		return fmt.Errorf("Config: incorrect ShutdownSecond %d, must be positive.", ss)
+	}
+	if pr := conf.ParseRetry; pr < 0 {
+		return fmt.Errorf("Config: incorrect ParseRetry %d, must be positive.", pr)
+	}
+	// check cache
+	c := conf.Cache
+	if c.Size < 0 || c.DirSize < 0 || c.ImageSize < 0 || c.TTLSecond < 0 {
+		return fmt.Errorf("Config: incorrect Cache %+v, must be positive.", c)
 	}
 	return nil
 }
@@ -38,15 +183,26 @@ const (
 	DefaultHTTP = "localhost:8080"
 
 	DefaultWaitSecond = 2
+
+	DefaultShutdownSecond = 10
+
+	DefaultCacheSize = 1000
+
+	DefaultImageCacheSize = 100
 )
 
 var DefaultConfig = Config{
-	HTTP: DefaultHTTP,
+	HTTP:           DefaultHTTP,
+	ShutdownSecond: DefaultShutdownSecond,
 	Sources: []Source{
 		DefaultSource,
 		FESource,
 		APSource,
 	},
+	Cache: Cache{
+		Size:      DefaultCacheSize,
+		ImageSize: DefaultImageCacheSize,
+	},
 }
 
 // DefaultSource has F.E. examination source.
@@ -70,6 +226,29 @@ var APSource = Source{
 	WaitSecond: DefaultWaitSecond,
 }
 
+// GetterOptions returns the options for src.Getter to access the sources.
+func (conf *Config) GetterOptions() []src.Option {
+	var opts []src.Option
+	if ua := conf.UserAgent; ua != "" {
+		opts = append(opts, src.WithUserAgent(ua))
+	}
+	if conf.Proxy != "" {
+		if proxy, err := url.Parse(conf.Proxy); err == nil {
+			opts = append(opts, src.WithProxy(proxy))
+		}
+	}
+	if ts := conf.TimeoutSecond; ts > 0 {
+		opts = append(opts, src.WithTimeout(time.Duration(ts)*time.Second))
+	}
+	for key, value := range conf.Headers {
+		opts = append(opts, src.WithHeader(key, value))
+	}
+	if pr := conf.ParseRetry; pr > 0 {
+		opts = append(opts, src.WithParseRetry(pr))
+	}
+	return opts
+}
+
 // Source is the source definition for getting questions.
 type Source struct {
 	src.Source
@@ -78,6 +257,12 @@ type Source struct {
 	SubAddr string
 	// Wait time for the requesting, in second.
 	WaitSecond int
+	// Local dataset, a directory or a zip archive created by mirror command.
+	// If it is set, the questions are served from it instead of the source server.
+	Dir string
+	// Interval for refreshing the catalogue of the sessions from IndexURL, in hour.
+	// 0 means it is refreshed at the start only.
+	RefreshHour int
 }
 
 // it loads the configuration from file.
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mzki/feserver/src"
+)
+
+// rewrite the image locations in the response according to the image mode.
+// The image which can not be obtained is remained as is.
+func (sub *subServer) rewriteImages(ctx context.Context, res *src.Response) {
+	switch sub.imageMode {
+	case ImageProxy:
+		for _, b := range res.Images() {
+			if u, err := url.Parse(b.Src); err == nil && u.Host != "" {
+				b.Src = sub.source.SubAddr + APIImages + u.Host + u.RequestURI()
+			}
+		}
+	case ImageEmbed:
+		for _, b := range res.Images() {
+			img, err := sub.getter.GetImage(ctx, b.Src)
+			if err != nil {
+				log.Println("Error: embedding image: " + err.Error())
+				continue
+			}
+			b.Src = img.DataURI()
+		}
+	}
+}
+
+// getImage serves the image in the question, located at the path
+// following APIImages. The location which is not an image is not found.
+func (sub *subServer) getImage(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := sub.timeoutContext(r)
+	defer cancel()
+
+	scheme := "http"
+	if u, err := url.Parse(sub.source.URL); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+	imageURL := scheme + "://" + strings.TrimPrefix(r.URL.Path, sub.source.SubAddr+APIImages)
+	if q := r.URL.RawQuery; q != "" {
+		imageURL += "?" + q
+	}
+
+	img, err := sub.getter.GetImage(ctx, imageURL)
+	switch {
+	case err == context.DeadlineExceeded:
+		http.Error(w, "Request timeout. Please try again later.", http.StatusGatewayTimeout)
+		return
+	case err == context.Canceled:
+		http.Error(w, "Request canceled.", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Println("Error: " + err.Error())
+		http.Error(w, "Image not found.", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if _, err := w.Write(img.Data); err != nil {
+		log.Println("Error: " + err.Error())
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"encoding/json"
 	"io"
+	"net/http"
 
 	"github.com/mzki/feserver/src"
 )
@@ -20,3 +21,10 @@ func (res *JSONResponse) write(w io.Writer) error {
 func writeJSON(w io.Writer, data interface{}) error {
 	return json.NewEncoder(w).Encode(data)
 }
+
+// it writes data as json, or the server error if failed.
+func writeJSONData(w http.ResponseWriter, data interface{}) {
+	if err := writeJSON(w, data); err != nil {
+		serverError(w, err, "Writing JSON Error. Check server log.", http.StatusInternalServerError)
+	}
+}
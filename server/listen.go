@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// it opens the listeners for the HTTP address and Listeners in the config.
+// DefaultHTTP is used if neither of them is set.
+// The listeners already opened are closed if any error occurs.
+func (s *Server) listen() ([]net.Listener, error) {
+	ls := s.conf.Listeners
+	switch {
+	case s.conf.HTTP != "":
+		ls = append([]Listener{{Addr: s.conf.HTTP}}, ls...)
+	case len(ls) == 0:
+		ls = []Listener{{Addr: DefaultHTTP}}
+	}
+
+	var opened []net.Listener
+	for _, l := range ls {
+		ln, err := s.listenOn(l)
+		if err != nil {
+			for _, ln := range opened {
+				ln.Close()
+			}
+			return nil, err
+		}
+		log.Println("listen on " + ln.Addr().Network() + " " + ln.Addr().String())
+		opened = append(opened, ln)
+	}
+	return opened, nil
+}
+
+func (s *Server) listenOn(l Listener) (net.Listener, error) {
+	network, addr := l.network()
+	if network == "unix" {
+		// the socket file is left when the last process is killed.
+		if fi, err := os.Stat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if err := os.Remove(addr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil || l.CertFile == "" {
+		return ln, err
+	}
+
+	cert, err := newCertReloader(l.CertFile, l.KeyFile)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	tlsConf := &tls.Config{GetCertificate: cert.getCertificate}
+	if s.conf.HTTP2 {
+		tlsConf.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return tls.NewListener(ln, tlsConf), nil
+}
+
+// it serves on the listeners until any of them fails or Shutdown is called.
+// It returns the first error.
+func (s *Server) serve(handler http.Handler, listeners []net.Listener) error {
+	s.server.Handler = handler
+	if !s.conf.HTTP2 {
+		// non-nil empty map disables HTTP/2.
+		s.server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+
+	errCh := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errCh <- s.server.Serve(ln)
+		}(ln)
+	}
+	err := <-errCh
+	if err != http.ErrServerClosed {
+		// stop the others.
+		s.server.Close()
+	}
+	return err
+}
+
+// certCheckInterval is the least interval for checking the modification
+// of the certificate files.
+const certCheckInterval = 10 * time.Second
+
+// certReloader provides the TLS certificate loaded from the files,
+// which is reloaded when the files are modified.
+// It is safe for concurrent use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	// the least interval for checking the files.
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: certCheckInterval}
+	if err := r.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// it returns the later modification time of the files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t := fi.ModTime(); t.After(last) {
+			last = t
+		}
+	}
+	return last, nil
+}
+
+// it must be called under the lock.
+func (r *certReloader) reload(now time.Time) error {
+	r.checkedAt = now
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// it is used as tls.Config.GetCertificate.
+// The previous certificate is kept if the files are broken,
+// such as while they are being replaced.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checkedAt) >= r.interval {
+		if err := r.reload(now); err != nil {
+			log.Println("Error: reloading certificate " + r.certFile + ": " + err.Error())
+		}
+	}
+	return r.cert, nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// it writes the self-signed certificate for localhost named cn into the files.
+func writeCert(t *testing.T, certFile, keyFile, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "feserver")
+	socket := filepath.Join(dir, "feserver.sock")
+
+	conf := &Config{
+		Listeners: []Listener{
+			{Addr: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile},
+			{Addr: unixPrefix + socket},
+		},
+		HTTP2: true,
+	}
+	if err := conf.validates(); err != nil {
+		t.Fatal(err)
+	}
+	server := New(conf)
+	listeners, err := server.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.serve(http.HandlerFunc(server.getStatsJSON), listeners) }()
+
+	// TLS with HTTP/2.
+	tlsClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	res, err := tlsClient.Get("https://" + listeners[0].Addr().String() + APIStats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.ProtoMajor != 2 {
+		t.Errorf("invalid response over TLS, got status: %d, proto: %s", res.StatusCode, res.Proto)
+	}
+
+	// Unix domain socket.
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	res, err = unixClient.Get("http://feserver" + APIStats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("invalid response over Unix socket, got status: %d", res.StatusCode)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != http.ErrServerClosed {
+		t.Errorf("serve must be closed by Shutdown, got: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket file must be removed, got: %v", err)
+	}
+}
+
+func TestListenDefault(t *testing.T) {
+	conf := &Config{}
+	if err := conf.validates(); err != nil {
+		t.Fatalf("config without any address must be accepted, got: %v", err)
+	}
+	listeners, err := New(conf).listen()
+	if err != nil {
+		t.Skipf("can not listen on %s: %v", DefaultHTTP, err)
+	}
+	defer listeners[0].Close()
+	if len(listeners) != 1 || listeners[0].Addr().Network() != "tcp" {
+		t.Errorf("must listen on %s only, got: %v", DefaultHTTP, listeners)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "old")
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.interval = 0
+	commonName := func() string {
+		cert, err := r.getCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x.Subject.CommonName
+	}
+	if cn := commonName(); cn != "old" {
+		t.Fatalf("invalid certificate, got: %s", cn)
+	}
+
+	// the renewed certificate is loaded.
+	writeCert(t, certFile, keyFile, "new")
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cn := commonName(); cn != "new" {
+		t.Errorf("certificate must be reloaded, got: %s", cn)
+	}
+
+	// the previous one is kept while the files are broken.
+	if err := ioutil.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if cn := commonName(); cn != "new" {
+		t.Errorf("previous certificate must be kept, got: %s", cn)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mzki/feserver/src"
+)
+
+// reviewItem is the answer history of a question for a user,
+// scheduled by the SM-2 spaced repetition algorithm.
+type reviewItem struct {
+	SubAddr string    `json:"subAddr"`
+	Query   src.Query `json:"query"`
+
+	Attempts    int       `json:"attempts"`
+	Corrects    int       `json:"corrects"`
+	LastCorrect bool      `json:"lastCorrect"`
+	LastAnswer  time.Time `json:"lastAnswer"`
+
+	// SM-2 parameters.
+	Repetition int       `json:"repetition"` // number of the successive correct answers.
+	Interval   int       `json:"interval"`   // in day.
+	EaseFactor float64   `json:"easeFactor"`
+	Due        time.Time `json:"due"` // next time to review.
+}
+
+const (
+	initialEaseFactor = 2.5
+	minEaseFactor     = 1.3
+
+	// quality of the answer in SM-2, from 0 to 5.
+	// the client answers only correct or not.
+	qualityCorrect = 4
+	qualityWrong   = 1
+)
+
+// update the schedule by the answer, following SM-2.
+func (item *reviewItem) update(correct bool, now time.Time) {
+	item.Attempts++
+	item.LastCorrect = correct
+	item.LastAnswer = now
+	if item.EaseFactor == 0 {
+		item.EaseFactor = initialEaseFactor
+	}
+
+	quality := qualityWrong
+	if correct {
+		item.Corrects++
+		quality = qualityCorrect
+	}
+
+	if quality >= 3 {
+		switch item.Repetition {
+		case 0:
+			item.Interval = 1
+		case 1:
+			item.Interval = 6
+		default:
+			item.Interval = int(math.Ceil(float64(item.Interval) * item.EaseFactor))
+		}
+		item.Repetition++
+	} else {
+		item.Repetition = 0
+		item.Interval = 1
+	}
+
+	d := float64(5 - quality)
+	item.EaseFactor += 0.1 - d*(0.08+d*0.02)
+	if item.EaseFactor < minEaseFactor {
+		item.EaseFactor = minEaseFactor
+	}
+	item.Due = now.AddDate(0, 0, item.Interval)
+}
+
+// progressStore holds the answer histories of the users.
+// The histories are saved to the file if given. The saving is delayed
+// for progressSaveDelay, so that the answers in the meantime are saved at once.
+type progressStore struct {
+	mu         sync.Mutex
+	file       string
+	users      map[string]map[string]*reviewItem // user -> item key -> item
+	lastActive map[string]time.Time              // user -> last answer time
+	maxUsers   int
+	scheduled  bool // whether the saving is scheduled.
+
+	saveMu sync.Mutex // serializes writing the file.
+}
+
+const (
+	// the user is removed after this time since the last answer.
+	progressLifeTime = 365 * 24 * time.Hour
+	// the maximum number of the users. the least recently active
+	// user is removed when a user is added over it.
+	maxProgressUsers = 10000
+	// the time to delay saving the histories after an answer.
+	progressSaveDelay = 10 * time.Second
+)
+
+// it returns the progressStore loading the file.
+// empty file means the histories are not saved.
+func newProgressStore(file string) *progressStore {
+	store := &progressStore{
+		file:       file,
+		users:      make(map[string]map[string]*reviewItem),
+		lastActive: make(map[string]time.Time),
+		maxUsers:   maxProgressUsers,
+	}
+	if file == "" {
+		return store
+	}
+	data, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+		// the first run.
+	case err != nil:
+		log.Println("Error: loading progress: " + err.Error())
+	default:
+		if err := json.Unmarshal(data, &store.users); err != nil {
+			log.Println("Error: loading progress: " + err.Error())
+		}
+	}
+	for user, items := range store.users {
+		for _, item := range items {
+			if item.LastAnswer.After(store.lastActive[user]) {
+				store.lastActive[user] = item.LastAnswer
+			}
+		}
+	}
+	return store
+}
+
+func reviewKey(subAddr string, q src.Query) string {
+	return subAddr + "#" + filepath.ToSlash(src.LocalPath(q))
+}
+
+// it records the answer of the user.
+func (store *progressStore) record(user, subAddr string, q src.Query, correct bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	items, ok := store.users[user]
+	if !ok {
+		store.evict(now)
+		items = make(map[string]*reviewItem)
+		store.users[user] = items
+	}
+	key := reviewKey(subAddr, q)
+	item, ok := items[key]
+	if !ok {
+		item = &reviewItem{SubAddr: subAddr, Query: q}
+		items[key] = item
+	}
+	item.update(correct, now)
+	store.lastActive[user] = now
+
+	if store.file != "" && !store.scheduled {
+		store.scheduled = true
+		time.AfterFunc(progressSaveDelay, func() {
+			if err := store.save(); err != nil {
+				log.Println("Error: saving progress: " + err.Error())
+			}
+		})
+	}
+}
+
+// remove the expired users, and the least recently active user
+// to add a user within the limit.
+// it must be called under the lock.
+func (store *progressStore) evict(now time.Time) {
+	var oldest string
+	for user, t := range store.lastActive {
+		if now.Sub(t) > progressLifeTime {
+			store.remove(user)
+		} else if oldest == "" || t.Before(store.lastActive[oldest]) {
+			oldest = user
+		}
+	}
+	if len(store.users) >= store.maxUsers && oldest != "" {
+		store.remove(oldest)
+	}
+}
+
+// it must be called under the lock.
+func (store *progressStore) remove(user string) {
+	delete(store.users, user)
+	delete(store.lastActive, user)
+}
+
+// it saves the histories to the file if the saving is scheduled.
+// It is called after progressSaveDelay since the answer, and at the shutdown.
+func (store *progressStore) save() error {
+	store.saveMu.Lock()
+	defer store.saveMu.Unlock()
+
+	store.mu.Lock()
+	if !store.scheduled {
+		store.mu.Unlock()
+		return nil
+	}
+	store.scheduled = false
+	data, err := json.Marshal(store.users)
+	store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return src.WriteFileAtomic(store.file, data)
+}
+
+// it returns the queries for the user to review in the range, ordered by priority.
+// The question answered wrongly at last has higher priority than the question
+// answered correctly, and the question due earlier has higher priority among them.
+func (store *progressStore) reviews(user, subAddr string, qr src.QueryRange, now time.Time) []src.Query {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var due []*reviewItem
+	for _, item := range store.users[user] {
+		if item.SubAddr == subAddr && !item.Due.After(now) && qr.Contains(item.Query) {
+			due = append(due, item)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if a, b := due[i].LastCorrect, due[j].LastCorrect; a != b {
+			return !a
+		}
+		return due[i].Due.Before(due[j].Due)
+	})
+
+	qs := make([]src.Query, len(due))
+	for i, item := range due {
+		qs[i] = item.Query
+	}
+	return qs
+}
@@ -13,6 +13,9 @@ import (
 
 const (
 	// query parameters for getQuestion.
+	// year is the year in era, or the Western year if it is not
+	// less than src.FirstWesternYear.
+	QueryEra    = "era"
 	QueryYear   = "year"
 	QuerySeason = "season"
 	QueryNo     = "no"
@@ -20,11 +23,13 @@ const (
 
 func parseGetQuestionQuery(v url.Values, source Source) (src.Query, error) {
 	q := src.Query{}
+	q.Era = v.Get(QueryEra)
 	q.Year = parseIntParam(v, QueryYear, q.Year)
 	q.No = parseIntParam(v, QueryNo, q.No)
 	if s := v.Get(QuerySeason); s != "" {
 		q.Season = s
 	}
+	q = q.Normalize()
 	if err := source.Source.Validates(q); err != nil {
 		return q, fmt.Errorf("invalid query form (%s), %v", v.Encode(), err)
 	}
@@ -38,6 +43,8 @@ const (
 	QueryMaxNo       = "max_no"
 	QueryMinNo       = "min_no"
 	QuerySeasonRange = "season"
+	QueryCategory    = "category" // name of the category or its field.
+	QueryTopic       = "topic"    // classification of the question at any level.
 )
 
 func parseGetRandomQuery(v url.Values, source Source) (src.QueryRange, error) {
@@ -49,12 +56,42 @@ func parseGetRandomQuery(v url.Values, source Source) (src.QueryRange, error) {
 	if s := v.Get(QuerySeasonRange); s != "" {
 		qr.Season = s
 	}
+	if c := v.Get(QueryCategory); c != "" {
+		qr.Category = c
+	}
 	if err := source.Source.ValidatesRange(qr); err != nil {
 		return qr, fmt.Errorf("invalid query form (%s), %v", v.Encode(), err)
 	}
 	return qr, nil
 }
 
+const (
+	// query parameters for the user and how to select the question randomly.
+	QueryUser = "user"
+	QueryMode = "mode"
+
+	// selects the question uniformly in the range. default.
+	ModeRandom = "random"
+	// selects the question which the user should review,
+	// and selects uniformly if no question to review.
+	ModeReview = "review"
+)
+
+func parseModeQuery(v url.Values) (mode, user string, err error) {
+	mode, user = v.Get(QueryMode), v.Get(QueryUser)
+	switch mode {
+	case "", ModeRandom:
+		return ModeRandom, user, nil
+	case ModeReview:
+		if user == "" {
+			return mode, user, fmt.Errorf("invalid query form (%s), %s is required for %s mode", v.Encode(), QueryUser, mode)
+		}
+		return mode, user, nil
+	default:
+		return mode, user, fmt.Errorf("invalid query form (%s), %s must be either %s or %s", v.Encode(), QueryMode, ModeRandom, ModeReview)
+	}
+}
+
 func parseIntParam(v url.Values, key string, _default int) int {
 	if param := v.Get(key); param != "" {
 		if i, err := strconv.Atoi(param); err == nil {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/url"
 	"testing"
+
+	"github.com/mzki/feserver/src"
 )
 
 func TestParseGetRandomQuery(t *testing.T) {
@@ -31,6 +33,22 @@ func TestParseGetRandomQuery(t *testing.T) {
 	assertEqualInt(t, qr.MaxNo, MaxNo, "")
 }
 
+func TestParseGetQuestionQuery(t *testing.T) {
+	v := url.Values{"year": {"2019"}, "season": {"aki"}, "no": {"1"}}
+	q, err := parseGetQuestionQuery(v, DefaultSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Era != src.EraReiwa || q.Year != 1 {
+		t.Errorf("western year must be converted into Reiwa 1, got: %+v", q)
+	}
+
+	v = url.Values{"era": {"r"}, "year": {"1"}, "season": {"haru"}, "no": {"1"}}
+	if _, err := parseGetQuestionQuery(v, DefaultSource); err == nil {
+		t.Error("Reiwa 1 spring must be error")
+	}
+}
+
 func assertEqualInt(t *testing.T, got, expect int, mes string) {
 	if got != expect {
 		t.Errorf("must be equal but got: %d, expect: %d, "+mes, got, expect)
@@ -0,0 +1,384 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mzki/feserver/src"
+)
+
+// Quiz session API. A session serves the questions without the answers
+// one by one, and checks the answers from the client.
+//
+//	POST [sub-address]/quiz                 creates a session.
+//	GET  [sub-address]/quiz/[id]/next       returns the current question.
+//	POST [sub-address]/quiz/[id]/answer     checks the answer for the current question.
+//	GET  [sub-address]/quiz/[id]/score      returns the score of the session.
+const (
+	APIQuiz = "/quiz"
+
+	quizNext   = "next"
+	quizAnswer = "answer"
+	quizScore  = "score"
+)
+
+const (
+	// query parameters for creating a quiz session.
+	// the parameters for getRandom are also accepted.
+	QueryCount = "count"
+	// query parameter for answering.
+	QueryChoice = "choice"
+)
+
+const (
+	DefaultQuizCount = 10
+	MaxQuizCount     = 100
+
+	// the session is removed after this time since the last access.
+	quizSessionLifeTime = time.Hour
+	// the maximum number of the sessions. the least recently accessed
+	// session is removed when a session is created over it.
+	maxQuizSessions = 10000
+)
+
+// it represents json response for the created quiz session.
+type QuizSessionResponse struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+	Error string `json:"error"`
+}
+
+// it represents json response for the question in the quiz session.
+// the answer and explanation are removed from the question.
+type QuizQuestionResponse struct {
+	src.Response
+	ID    string `json:"id"`
+	Index int    `json:"index"` // 0-based index of the question in the session.
+	Count int    `json:"count"`
+	Error string `json:"error"`
+}
+
+// it represents json response for the answer in the quiz session.
+type QuizAnswerResponse struct {
+	Correct         bool         `json:"correct"`
+	Choice          string       `json:"choice"` // the answer from the client.
+	Answer          string       `json:"answer"` // the correct answer.
+	Explanation     string       `json:"explanation"`
+	ExplanationBody []src.Block  `json:"explanationBody"`
+	Choices         []src.Choice `json:"choices"` // selections with their explanations.
+	Error           string       `json:"error"`
+}
+
+// it represents json response for the score of the quiz session.
+type QuizScoreResponse struct {
+	ID       string `json:"id"`
+	Count    int    `json:"count"`
+	Answered int    `json:"answered"`
+	Correct  int    `json:"correct"`
+	Finished bool   `json:"finished"`
+	Error    string `json:"error"`
+}
+
+// quizSession is a state of the quiz.
+type quizSession struct {
+	mu sync.Mutex
+
+	// the settings are not changed after the creation.
+	id         string
+	qr         src.QueryRange
+	topic      string
+	mode, user string
+	count      int
+
+	asked    map[src.Query]bool
+	current  *src.Response // the question presented but not answered yet.
+	currentQ src.Query
+	answered int
+	correct  int
+
+	// it is guarded by the mutex of quizStore.
+	lastAccess time.Time
+}
+
+var errQuizFinished = errors.New("all of the questions are answered. see score.")
+
+// quizStore holds the quiz sessions.
+// The store never waits for the lock of the session,
+// which is held while the session is in use.
+type quizStore struct {
+	mu          sync.Mutex
+	sessions    map[string]*quizSession
+	maxSessions int
+}
+
+func newQuizStore() *quizStore {
+	return &quizStore{
+		sessions:    make(map[string]*quizSession),
+		maxSessions: maxQuizSessions,
+	}
+}
+
+func (store *quizStore) create(qr src.QueryRange, topic, mode, user string, count int) (*quizSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	session := &quizSession{
+		id:         id,
+		qr:         qr,
+		topic:      topic,
+		mode:       mode,
+		user:       user,
+		count:      count,
+		asked:      make(map[src.Query]bool, count),
+		lastAccess: time.Now(),
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	// remove the expired sessions, and the least recently accessed
+	// sessions over the limit.
+	var oldest string
+	for id, s := range store.sessions {
+		if time.Since(s.lastAccess) > quizSessionLifeTime {
+			delete(store.sessions, id)
+		} else if oldest == "" || s.lastAccess.Before(store.sessions[oldest].lastAccess) {
+			oldest = id
+		}
+	}
+	if len(store.sessions) >= store.maxSessions && oldest != "" {
+		delete(store.sessions, oldest)
+	}
+	store.sessions[id] = session
+	return session, nil
+}
+
+// it returns the session, and updates its last access time.
+// The expired session is not returned.
+func (store *quizStore) get(id string) (*quizSession, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	s, ok := store.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(s.lastAccess) > quizSessionLifeTime {
+		delete(store.sessions, id)
+		return nil, false
+	}
+	s.lastAccess = time.Now()
+	return s, true
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// quiz handles the quiz session API.
+func (sub *subServer) quiz(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, sub.source.SubAddr+APIQuiz)
+	path = strings.Trim(path, "/")
+	if path == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		sub.createQuiz(w, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	session, ok := sub.quizzes.get(parts[0])
+	if !ok {
+		writeJSONData(w, &QuizSessionResponse{Error: "quiz session is not found or expired."})
+		return
+	}
+
+	switch action, method := parts[1], r.Method; {
+	case action == quizNext && method == http.MethodGet:
+		sub.handler(func(ctx context.Context, r *http.Request) *JSONResponse {
+			return sub.nextQuiz(ctx, session)
+		}, writeQuizQuestion(session))(w, r)
+	case action == quizAnswer && method == http.MethodPost:
+		writeJSONData(w, sub.answerQuiz(session, r.FormValue(QueryChoice)))
+	case action == quizScore && method == http.MethodGet:
+		writeJSONData(w, session.score())
+	case action == quizNext || action == quizAnswer || action == quizScore:
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (sub *subServer) createQuiz(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSONData(w, &QuizSessionResponse{Error: err.Error()})
+		return
+	}
+	qr, err := parseGetRandomQuery(r.Form, sub.currentSource())
+	if err != nil {
+		writeJSONData(w, &QuizSessionResponse{Error: err.Error()})
+		return
+	}
+	mode, user, err := parseModeQuery(r.Form)
+	if err != nil {
+		writeJSONData(w, &QuizSessionResponse{Error: err.Error()})
+		return
+	}
+	count := parseIntParam(r.Form, QueryCount, DefaultQuizCount)
+	if count <= 0 || count > MaxQuizCount {
+		writeJSONData(w, &QuizSessionResponse{
+			Error: fmt.Sprintf("invalid %s %d, must be in [1:%d]", QueryCount, count, MaxQuizCount),
+		})
+		return
+	}
+
+	session, err := sub.quizzes.create(qr, r.Form.Get(QueryTopic), mode, user, count)
+	if err != nil {
+		serverError(w, err, "Creating Session Error. Check server log.", http.StatusInternalServerError)
+		return
+	}
+	writeJSONData(w, &QuizSessionResponse{ID: session.id, Count: count})
+}
+
+// it returns the current question of the session.
+// new question is selected if the current question is answered.
+func (sub *subServer) nextQuiz(ctx context.Context, s *quizSession) *JSONResponse {
+	s.mu.Lock()
+	if s.current != nil {
+		res := *s.current
+		s.mu.Unlock()
+		return &JSONResponse{Response: res}
+	}
+	if s.answered >= s.count {
+		s.mu.Unlock()
+		return &JSONResponse{Error: errQuizFinished.Error()}
+	}
+	asked := make(map[src.Query]bool, len(s.asked))
+	for q := range s.asked {
+		asked[q] = true
+	}
+	s.mu.Unlock()
+
+	// select the question not asked yet, as possible.
+	// the lock is not held while getting the question, which may wait
+	// for the interval time of the requests to the source server.
+	q, res, err := sub.getSelected(ctx, s.qr, s.topic, s.mode, s.user, asked)
+	if err != nil {
+		return &JSONResponse{Error: err.Error()}
+	}
+	sub.rewriteImages(ctx, &res)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		// the other request got the question meanwhile.
+		return &JSONResponse{Response: *s.current}
+	}
+	s.asked[q] = true
+	s.current = &res
+	s.currentQ = q
+	return &JSONResponse{Response: res}
+}
+
+// it returns the writer for the question without the answer.
+func writeQuizQuestion(s *quizSession) responseWriter {
+	return func(w http.ResponseWriter, jres *JSONResponse) error {
+		s.mu.Lock()
+		qres := &QuizQuestionResponse{
+			Response: withoutAnswer(jres.Response),
+			ID:       s.id,
+			Index:    s.answered,
+			Count:    s.count,
+			Error:    jres.Error,
+		}
+		s.mu.Unlock()
+		return writeJSON(w, qres)
+	}
+}
+
+// it returns the copy of response in which the answer and explanation are removed.
+func withoutAnswer(res src.Response) src.Response {
+	res.Answer = ""
+	res.Explanation = ""
+	res.ExplanationBody = nil
+	choices := make([]src.Choice, len(res.Choices))
+	for i, c := range res.Choices {
+		c.Explanation = nil
+		choices[i] = c
+	}
+	res.Choices = choices
+	return res
+}
+
+// it checks the choice for the current question,
+// and records the answer if the session has the user.
+func (sub *subServer) answerQuiz(s *quizSession, choice string) *QuizAnswerResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		if s.answered >= s.count {
+			return &QuizAnswerResponse{Error: errQuizFinished.Error()}
+		}
+		return &QuizAnswerResponse{Error: "no question to answer. get next question first."}
+	}
+	if !validChoice(*s.current, choice) {
+		return &QuizAnswerResponse{Error: fmt.Sprintf("invalid %s %q", QueryChoice, choice)}
+	}
+
+	res := s.current
+	correct := choice == strings.TrimSpace(res.Answer)
+	s.current = nil
+	s.answered++
+	if correct {
+		s.correct++
+	}
+	if s.user != "" {
+		sub.progress.record(s.user, sub.source.SubAddr, s.currentQ, correct)
+	}
+	return &QuizAnswerResponse{
+		Correct:         correct,
+		Choice:          choice,
+		Answer:          res.Answer,
+		Explanation:     res.Explanation,
+		ExplanationBody: res.ExplanationBody,
+		Choices:         res.Choices,
+	}
+}
+
+func validChoice(res src.Response, choice string) bool {
+	for _, c := range structured(res).Choices {
+		if c.Label == choice {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *quizSession) score() *QuizScoreResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &QuizScoreResponse{
+		ID:       s.id,
+		Count:    s.count,
+		Answered: s.answered,
+		Correct:  s.correct,
+		Finished: s.answered >= s.count,
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mzki/feserver/src"
+)
+
+// it returns the response having the structured contents.
+// The response prior to version 2.0.0 has only flat fields,
+// so that the structured contents are made from them.
+func structured(res src.Response) src.Response {
+	if len(res.Body) > 0 || len(res.Choices) > 0 {
+		return res
+	}
+	res.Body = textBlocks(res.Question)
+	for _, sel := range res.Selections {
+		label, text := "", sel
+		if i := strings.Index(sel, ":"); i >= 0 {
+			label, text = strings.TrimSpace(sel[:i]), sel[i+1:]
+		}
+		res.Choices = append(res.Choices, src.Choice{Label: label, Content: textBlocks(text)})
+	}
+	res.ExplanationBody = textBlocks(res.Explanation)
+	return res
+}
+
+func textBlocks(text string) []src.Block {
+	if text = strings.TrimSpace(text); text == "" {
+		return nil
+	}
+	return []src.Block{{Type: src.BlockParagraph, Text: text}}
+}
+
+// it writes the response as the self-contained html page.
+func writeHTMLResponse(w http.ResponseWriter, jres *JSONResponse) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := *jres
+	data.Response = structured(jres.Response)
+	return htmlTemplate.Execute(w, &data)
+}
+
+var htmlTemplate = template.Must(template.New("question.html").Funcs(template.FuncMap{
+	"lines": func(s string) []string { return strings.Split(s, "\n") },
+	"imgsrc": func(s string) interface{} {
+		// the data URI is made by this server, not by the source.
+		if strings.HasPrefix(s, "data:image/") {
+			return template.URL(s)
+		}
+		return s
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>{{if .Error}}Error{{else}}{{.URL}}{{end}}</title>
+<style>
+body { max-width: 48em; margin: 1em auto; padding: 0 1em; line-height: 1.6; }
+table { border-collapse: collapse; }
+td { border: 1px solid #888; padding: 0.2em 0.5em; }
+pre { background: #f4f4f4; padding: 0.5em; }
+img { max-width: 100%; }
+.choices { list-style: none; padding: 0; }
+.label { font-weight: bold; margin-right: 0.5em; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+{{- if .Error}}
+<p class="error">{{.Error}}</p>
+{{- else}}
+<section class="question">{{template "blocks" .Body}}</section>
+<ul class="choices">
+{{- range .Choices}}
+<li><span class="label">{{.Label}}</span>{{template "blocks" .Content}}</li>
+{{- end}}
+</ul>
+<details>
+<summary>解答・解説</summary>
+<p class="answer">正解: {{.Answer}}</p>
+<section class="explanation">{{template "blocks" .ExplanationBody}}</section>
+</details>
+<p class="source">出典: <a href="{{.URL}}">{{.URL}}</a></p>
+{{- end}}
+</body>
+</html>
+{{define "blocks"}}{{range .}}{{template "block" .}}{{end}}{{end}}
+{{- define "block"}}
+{{- if eq .Type "paragraph"}}<p>{{range $i, $l := lines .Text}}{{if $i}}<br>{{end}}{{$l}}{{end}}</p>
+{{- else if eq .Type "image"}}<img src="{{imgsrc .Src}}" alt="{{.Alt}}">
+{{- else if eq .Type "table"}}<table>{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}</table>
+{{- else if eq .Type "code"}}<pre><code>{{.Text}}</code></pre>
+{{- else if eq .Type "formula"}}<code class="formula">{{.Text}}</code>
+{{- end}}
+{{- end}}
+`))
+
+// it writes the response as the Markdown document.
+func writeMarkdownResponse(w http.ResponseWriter, jres *JSONResponse) error {
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	return writeMarkdown(w, jres)
+}
+
+func writeMarkdown(w io.Writer, jres *JSONResponse) error {
+	if jres.Error != "" {
+		_, err := fmt.Fprintf(w, "**Error:** %s\n", jres.Error)
+		return err
+	}
+	res := structured(jres.Response)
+
+	md := new(strings.Builder)
+	writeMarkdownBlocks(md, res.Body, "\n\n", "")
+	md.WriteString("\n\n")
+	for _, c := range res.Choices {
+		fmt.Fprintf(md, "- **%s** ", c.Label)
+		writeMarkdownBlocks(md, c.Content, " ", "  ")
+		md.WriteString("\n")
+	}
+	md.WriteString("\n<details>\n<summary>解答・解説</summary>\n\n")
+	fmt.Fprintf(md, "正解: **%s**\n\n", res.Answer)
+	writeMarkdownBlocks(md, res.ExplanationBody, "\n\n", "")
+	md.WriteString("\n\n</details>\n")
+	if res.URL != "" {
+		fmt.Fprintf(md, "\n出典: <%s>\n", res.URL)
+	}
+
+	_, err := io.WriteString(w, md.String())
+	return err
+}
+
+// it writes the blocks separated by sep. The lines after the first line are
+// indented by indent, so that the blocks are nested in the list item.
+// The table and the code block are separated by a blank line from the others,
+// and they start on the new line in the list item.
+func writeMarkdownBlocks(md *strings.Builder, blocks []src.Block, sep, indent string) {
+	for i, b := range blocks {
+		switch {
+		case isMarkdownBlockLevel(b) && (i > 0 || indent != ""),
+			i > 0 && isMarkdownBlockLevel(blocks[i-1]):
+			md.WriteString("\n\n" + indent)
+		case i > 0:
+			md.WriteString(sep)
+		}
+		md.WriteString(strings.Replace(markdownBlock(b), "\n", "\n"+indent, -1))
+	}
+}
+
+func isMarkdownBlockLevel(b src.Block) bool {
+	return b.Type == src.BlockTable || b.Type == src.BlockCode
+}
+
+// it escapes the table cell, which must be in a line and not contain the delimiter.
+var markdownCell = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+
+func markdownBlock(b src.Block) string {
+	switch b.Type {
+	case src.BlockParagraph:
+		// hard line break in Markdown.
+		return strings.Replace(b.Text, "\n", "  \n", -1)
+	case src.BlockImage:
+		return fmt.Sprintf("![%s](%s)", b.Alt, b.Src)
+	case src.BlockTable:
+		rows := make([]string, 0, len(b.Rows)+1)
+		for j, row := range b.Rows {
+			cells := make([]string, len(row))
+			for k, cell := range row {
+				cells[k] = markdownCell.Replace(cell)
+			}
+			rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+			if j == 0 {
+				rows = append(rows, strings.Repeat("| --- ", len(row))+"|")
+			}
+		}
+		return strings.Join(rows, "\n")
+	case src.BlockCode:
+		return "```\n" + b.Text + "\n```"
+	case src.BlockFormula:
+		return "$" + b.Text + "$"
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mzki/feserver/src"
+)
+
+// represents API for searching the questions stored locally.
+//
+//	GET [sub-address]/search.json?q=[text]&limit=[limit]
+const APISearch = "/search.json"
+
+const (
+	// query parameters for search.
+	QuerySearch = "q"
+	QueryLimit  = "limit"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// it represents json response for the search.
+type SearchResponse struct {
+	Hits    []SearchHit `json:"hits"`
+	Total   int         `json:"total"`   // the number of the matched questions.
+	Indexed int         `json:"indexed"` // the number of the searched questions.
+	Error   string      `json:"error"`
+}
+
+// SearchHit is a question matched in the search.
+// The question can be got by question.json with Era, Year, Season and No.
+type SearchHit struct {
+	Era     string  `json:"era"`
+	Year    int     `json:"year"`
+	Season  string  `json:"season"`
+	No      int     `json:"no"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// it indexes the questions stored in the cache or the local dataset.
+// the questions got later are indexed by get.
+func (sub *subServer) indexStored(ctx context.Context) {
+	n, err := sub.index.AddStored(ctx, sub.getter, src.MaxQueryRange)
+	if err != nil {
+		log.Println("Error: indexing " + sub.source.SubAddr + ": " + err.Error())
+		return
+	}
+	log.Printf("indexed %d questions for %q", n, sub.source.SubAddr)
+}
+
+// it gets the question and indexes it for the search.
+func (sub *subServer) get(ctx context.Context, q src.Query) (src.Response, error) {
+	res, err := sub.getter.Get(ctx, q)
+	if err == nil {
+		sub.index.Add(q, res)
+	}
+	return res, err
+}
+
+func (sub *subServer) search(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	text := v.Get(QuerySearch)
+	if text == "" {
+		writeJSONData(w, &SearchResponse{Error: fmt.Sprintf("invalid query form (%s), %s is required", v.Encode(), QuerySearch)})
+		return
+	}
+	limit := parseIntParam(v, QueryLimit, DefaultSearchLimit)
+	if limit <= 0 || limit > MaxSearchLimit {
+		writeJSONData(w, &SearchResponse{Error: fmt.Sprintf("invalid %s %d, must be in [1:%d]", QueryLimit, limit, MaxSearchLimit)})
+		return
+	}
+
+	hits, total := sub.index.Search(text, limit)
+	res := &SearchResponse{
+		Hits:    make([]SearchHit, len(hits)),
+		Total:   total,
+		Indexed: sub.index.Len(),
+	}
+	for i, h := range hits {
+		res.Hits[i] = SearchHit{
+			Era:     h.Query.Normalize().Era,
+			Year:    h.Query.Year,
+			Season:  h.Query.Season,
+			No:      h.Query.No,
+			Score:   h.Score,
+			Snippet: h.Snippet,
+		}
+	}
+	writeJSONData(w, res)
+}
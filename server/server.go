@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
-)
+	"time"
 
-// TODO: cache for Response from source.
+	"github.com/mzki/feserver/src"
+)
 
 // represents server which can get F.E. question from the external server,
 // and can return json response containing F.E. question.
@@ -13,6 +16,14 @@ type Server struct {
 	server     *http.Server
 	subServers map[string]*subServer
 	conf       Config
+	cache      src.Cache
+	imageCache src.Cache
+	progress   *progressStore
+
+	// ctx is the base of the request contexts and the background works,
+	// which is canceled by Shutdown.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // it returns new constructed server with config.
@@ -22,16 +33,66 @@ func New(conf *Config) *Server {
 		conf = &DefaultConfig
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	cache := newCache(conf.Cache)
+	imageCache := newImageCache(conf.Cache)
+	progress := newProgressStore(conf.ProgressFile)
 	ss := make(map[string]*subServer, len(conf.Sources))
 	for _, s := range conf.Sources {
-		ss[s.SubAddr] = newSubServer(s)
+		ss[s.SubAddr] = newSubServer(ctx, s, conf, cache, imageCache, progress)
 	}
 
 	return &Server{
-		server:     &http.Server{},
+		server: &http.Server{
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		},
 		subServers: ss,
 		conf:       *conf,
+		cache:      cache,
+		imageCache: imageCache,
+		progress:   progress,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// it returns the cache constructed by config.
+// nil is returned if the cache is disabled.
+func newCache(c Cache) src.Cache {
+	ttl := time.Duration(c.TTLSecond) * time.Second
+
+	var caches []src.Cache
+	if c.Size > 0 {
+		caches = append(caches, src.NewMemoryCache(c.Size, ttl))
+	}
+	if c.Dir != "" {
+		fc, err := src.NewFileCache(c.Dir, c.DirSize, ttl)
+		if err != nil {
+			// the server can work without on-disk cache.
+			log.Println("Error: on-disk cache is disabled: " + err.Error())
+		} else {
+			caches = append(caches, fc)
+		}
+	}
+
+	switch len(caches) {
+	case 0:
+		return nil
+	case 1:
+		return caches[0]
+	default:
+		return src.NewTieredCache(caches...)
+	}
+}
+
+// it returns the in-memory cache for the images constructed by config,
+// which is apart from the cache for the responses.
+// nil is returned if the cache is disabled.
+func newImageCache(c Cache) src.Cache {
+	if c.ImageSize <= 0 {
+		return nil
 	}
+	return src.NewMemoryCache(c.ImageSize, time.Duration(c.TTLSecond)*time.Second)
 }
 
 const (
@@ -39,18 +100,32 @@ const (
 	APIGetRandom = "/r-question.json"
 	// represents API for getting question with specified query.
 	APIGetQuestion = "/question.json"
+	// represents APIs for getting question rendered as html page or Markdown document.
+	APIGetRandomHTML       = "/r-question.html"
+	APIGetQuestionHTML     = "/question.html"
+	APIGetRandomMarkdown   = "/r-question.md"
+	APIGetQuestionMarkdown = "/question.md"
+	// represents API for getting the image in the question.
+	// the image URL follows it, such as /images/www.fe-siken.com/kakomon/28_haru/img/02.png.
+	APIImages = "/images/"
+	// represents API for getting server statistics.
+	// it is served on the top-level address only.
+	APIStats = "/stats.json"
 )
 
-// it starts server process.
+// it starts server process listening on the HTTP address and Listeners in the config.
 // it blocks until process occurs any error and
-// return the error.
+// return the error. After Shutdown is called,
+// it returns http.ErrServerClosed immediately.
 func (s *Server) ListenAndServe() error {
+	if s.ctx.Err() != nil {
+		// shut down already, the addresses are not bound.
+		return http.ErrServerClosed
+	}
 	if err := s.conf.validates(); err != nil {
 		return err
 	}
 
-	serverURL := s.conf.HTTP
-
 	handler := http.NewServeMux()
 	for addr, sub := range s.subServers {
 		for _, api := range []struct {
@@ -59,15 +134,42 @@ func (s *Server) ListenAndServe() error {
 		}{
 			{addr + APIGetRandom, sub.getRandomQuestionJSON},
 			{addr + APIGetQuestion, sub.getQuestionJSON},
+			{addr + APIGetRandomHTML, sub.handler(sub.getRandom, writeHTMLResponse)},
+			{addr + APIGetQuestionHTML, sub.handler(sub.getQuestion, writeHTMLResponse)},
+			{addr + APIGetRandomMarkdown, sub.handler(sub.getRandom, writeMarkdownResponse)},
+			{addr + APIGetQuestionMarkdown, sub.handler(sub.getQuestion, writeMarkdownResponse)},
+			{addr + APIImages, sub.getImage},
+			{addr + APIQuiz, sub.quiz},
+			{addr + APIQuiz + "/", sub.quiz},
+			{addr + APISearch, sub.search},
 		} {
 			handler.HandleFunc(api.path, api.handler)
-			log.Println("listen on " + serverURL + api.path)
+			log.Println("serve " + api.path)
 		}
 	}
+	handler.HandleFunc(APIStats, s.getStatsJSON)
+	log.Println("serve " + APIStats)
+
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	return s.serve(handler, listeners)
+}
 
-	s.server.Addr = serverURL
-	s.server.Handler = handler
-	return s.server.ListenAndServe()
+// Shutdown gracefully shuts down the server.
+// It stops accepting the new requests, and waits for the active requests
+// until ctx is done. Then the requests still active are canceled,
+// and the background works such as refreshing the sessions are stopped.
+// The answer histories not saved yet are saved at last.
+// It returns ctx.Err() if ctx is done before the active requests.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	s.cancel()
+	if perr := s.progress.save(); perr != nil {
+		log.Println("Error: saving progress: " + perr.Error())
+	}
+	return err
 }
 
 // It starts server process using default server with
@@ -82,3 +184,29 @@ func ListenAndServe(conf *Config) error {
 	defaultServer := New(conf)
 	return defaultServer.ListenAndServe()
 }
+
+// it represents json response for the server statistics.
+type StatsResponse struct {
+	Cache src.CacheStats `json:"cache"`
+	// statistics of the cache for the images, counted apart from the responses.
+	Images src.CacheStats `json:"images"`
+	// status of the source servers by the sub addresses.
+	Sources map[string]src.BreakerStatus `json:"sources"`
+}
+
+func (s *Server) getStatsJSON(w http.ResponseWriter, r *http.Request) {
+	var stats StatsResponse
+	if s.cache != nil {
+		stats.Cache = s.cache.Stats()
+	}
+	if s.imageCache != nil {
+		stats.Images = s.imageCache.Stats()
+	}
+	stats.Sources = make(map[string]src.BreakerStatus, len(s.subServers))
+	for addr, sub := range s.subServers {
+		stats.Sources[addr] = sub.getter.UpstreamStatus()
+	}
+	if err := writeJSON(w, &stats); err != nil {
+		serverError(w, err, "Writing JSON Error. Check server log.", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,515 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mzki/feserver/src"
+)
+
+// png header, enough to be detected as image/png.
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestGetImage(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kakomon/28_haru/img/02.png":
+			w.Write(pngData)
+		case "/kakomon/28_haru/q2.html":
+			w.Write([]byte("<html><body>page</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	s := FESource
+	s.URL = upstream.URL + "/kakomon/{{.Year}}_{{.Season}}/q{{.No}}.html"
+	conf := &Config{Sources: []Source{s}, Image: ImageProxy}
+	sub := newSubServer(context.Background(), s, conf, nil, nil, newProgressStore(""))
+
+	// rewrite to the images API of this server.
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	res := src.Response{Body: []src.Block{{Type: src.BlockImage, Src: upstream.URL + "/kakomon/28_haru/img/02.png"}}}
+	sub.rewriteImages(context.Background(), &res)
+	want := "/fe" + APIImages + host + "/kakomon/28_haru/img/02.png"
+	if got := res.Body[0].Src; got != want {
+		t.Fatalf("image location is not rewritten, got: %s, want: %s", got, want)
+	}
+
+	// serve the image through the images API.
+	w := httptest.NewRecorder()
+	sub.getImage(w, httptest.NewRequest("GET", want, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("invalid status, got: %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("invalid content type, got: %s", ct)
+	}
+	if body, _ := ioutil.ReadAll(w.Body); string(body) != string(pngData) {
+		t.Error("invalid image data")
+	}
+
+	// images in the other host and the locations other than the images are not served.
+	for _, loc := range []string{
+		"example.com/a.png",
+		host + "/kakomon/28_haru/q2.html",
+	} {
+		w = httptest.NewRecorder()
+		sub.getImage(w, httptest.NewRequest("GET", "/fe"+APIImages+loc, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: must not be served, got status: %d", loc, w.Code)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	jres := &JSONResponse{Response: src.Response{
+		Body: []src.Block{
+			{Type: src.BlockParagraph, Text: "line1\nline2"},
+			{Type: src.BlockImage, Src: "data:image/png;base64,AAAA", Alt: "fig"},
+		},
+		Choices: []src.Choice{
+			{Label: "ア", Content: []src.Block{{Type: src.BlockParagraph, Text: "<a>"}}},
+		},
+		Answer: "ア",
+		URL:    "http://www.fe-siken.com/kakomon/28_haru/q2.html",
+	}}
+
+	w := httptest.NewRecorder()
+	if err := writeHTMLResponse(w, jres); err != nil {
+		t.Fatal(err)
+	}
+	html := w.Body.String()
+	for _, want := range []string{"line1<br>line2", `src="data:image/png;base64,AAAA"`, "&lt;a&gt;", "<details>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html must contain %q", want)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	if err := writeMarkdownResponse(w, jres); err != nil {
+		t.Fatal(err)
+	}
+	md := w.Body.String()
+	for _, want := range []string{"line1  \nline2", "![fig](data:image/png;base64,AAAA)", "- **ア** <a>", "正解: **ア**"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown must contain %q", want)
+		}
+	}
+}
+
+func TestRenderMarkdownNested(t *testing.T) {
+	jres := &JSONResponse{Response: src.Response{
+		Choices: []src.Choice{
+			{Label: "ア", Content: []src.Block{
+				{Type: src.BlockParagraph, Text: "x\ny"},
+				{Type: src.BlockTable, Rows: [][]string{{"a", "b"}, {"1", "2"}, {"x|y", "p\nq"}}},
+			}},
+			{Label: "イ", Content: []src.Block{{Type: src.BlockCode, Text: "c"}}},
+		},
+		Answer: "ア",
+	}}
+
+	w := httptest.NewRecorder()
+	if err := writeMarkdownResponse(w, jres); err != nil {
+		t.Fatal(err)
+	}
+	md := w.Body.String()
+	for _, want := range []string{
+		"- **ア** x  \n  y\n\n  | a | b |\n  | --- | --- |\n  | 1 | 2 |\n",
+		"  | x\\|y | p<br>q |\n",
+		"- **イ** \n\n  ```\n  c\n  ```\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown must contain %q, got:\n%s", want, md)
+		}
+	}
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "|") || strings.HasPrefix(line, "```") {
+			t.Errorf("nested block must be indented in the list item, got line %q", line)
+		}
+	}
+}
+
+func TestRenderFlatResponse(t *testing.T) {
+	res := structured(src.Response{
+		Question:   "question",
+		Selections: []string{"ア: one", "イ: two"},
+	})
+	if len(res.Body) != 1 || len(res.Choices) != 2 || res.Choices[1].Label != "イ" {
+		t.Errorf("structured contents must be made from flat fields, got: %+v", res)
+	}
+}
+
+// it returns the source serving the responses from the local directory.
+func localSource(t *testing.T, responses map[src.Query]src.Response) Source {
+	dir, err := ioutil.TempDir("", "feserver-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for q, res := range responses {
+		file := filepath.Join(dir, src.LocalPath(q)+src.ExtJSON)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := FESource
+	s.Dir = dir
+	return s
+}
+
+func TestQuiz(t *testing.T) {
+	q := src.Query{Year: 28, Season: src.SeasonSpring, No: 1}
+	s := localSource(t, map[src.Query]src.Response{
+		q: {
+			Question:    "question",
+			Selections:  []string{"ア: one", "イ: two", "ウ: three", "エ: four"},
+			Answer:      "イ",
+			Explanation: "explanation",
+			Version:     src.JSONVersion,
+		},
+	})
+	defer os.RemoveAll(s.Dir)
+	sub := newSubServer(context.Background(), s, &Config{Sources: []Source{s}}, nil, nil, newProgressStore(""))
+
+	do := func(method, path string, data interface{}) {
+		w := httptest.NewRecorder()
+		sub.quiz(w, httptest.NewRequest(method, path, nil))
+		if err := json.NewDecoder(w.Body).Decode(data); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+
+	var session QuizSessionResponse
+	do("POST", "/fe/quiz?count=1&max_year=28&min_year=28&season=haru&max_no=1&min_no=1", &session)
+	if session.Error != "" || session.ID == "" {
+		t.Fatalf("session must be created, got: %+v", session)
+	}
+	base := "/fe/quiz/" + session.ID
+
+	var question QuizQuestionResponse
+	do("GET", base+"/next", &question)
+	if question.Error != "" || question.Question != "question" {
+		t.Fatalf("question must be returned, got: %+v", question)
+	}
+	if question.Answer != "" || question.Explanation != "" {
+		t.Errorf("answer must not be returned, got: %+v", question)
+	}
+
+	var answer QuizAnswerResponse
+	do("POST", base+"/answer?choice=イ", &answer)
+	if answer.Error != "" || !answer.Correct || answer.Explanation != "explanation" {
+		t.Errorf("answer must be correct, got: %+v", answer)
+	}
+
+	var score QuizScoreResponse
+	do("GET", base+"/score", &score)
+	if !score.Finished || score.Correct != 1 || score.Answered != 1 {
+		t.Errorf("invalid score, got: %+v", score)
+	}
+
+	do("GET", base+"/next", &question)
+	if question.Error == "" {
+		t.Error("finished session must not return question")
+	}
+}
+
+func TestQuizStore(t *testing.T) {
+	store := newQuizStore()
+	store.maxSessions = 2
+	create := func() string {
+		s, err := store.create(src.MaxQueryRange, "", ModeRandom, "", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.id
+	}
+
+	// the expired session is removed.
+	expired := create()
+	store.mu.Lock()
+	store.sessions[expired].lastAccess = time.Now().Add(-2 * quizSessionLifeTime)
+	store.mu.Unlock()
+	if _, ok := store.get(expired); ok {
+		t.Error("expired session must not be returned")
+	}
+
+	// the least recently accessed session is removed over the limit.
+	a, b := create(), create()
+	store.mu.Lock()
+	store.sessions[a].lastAccess = time.Now().Add(-time.Minute)
+	store.mu.Unlock()
+	store.get(b)
+	c := create()
+	if _, ok := store.get(a); ok {
+		t.Error("least recently accessed session must be removed")
+	}
+	for _, id := range []string{b, c} {
+		if _, ok := store.get(id); !ok {
+			t.Errorf("session %s must be kept", id)
+		}
+	}
+	if n := len(store.sessions); n != 2 {
+		t.Errorf("invalid number of sessions, got: %d", n)
+	}
+
+	// the store is not blocked by the session in use.
+	s, _ := store.get(c)
+	s.mu.Lock()
+	create()
+	s.mu.Unlock()
+}
+
+func TestGetRandomTopic(t *testing.T) {
+	db := src.Query{Year: 28, Season: src.SeasonSpring, No: 1}
+	nw := src.Query{Year: 28, Season: src.SeasonSpring, No: 2}
+	s := localSource(t, map[src.Query]src.Response{
+		db: {Question: "db", Topic: []string{"テクノロジ系", "技術要素", "データベース"}, Version: src.JSONVersion},
+		nw: {Question: "nw", Topic: []string{"テクノロジ系", "技術要素", "ネットワーク"}, Version: src.JSONVersion},
+	})
+	defer os.RemoveAll(s.Dir)
+	sub := newSubServer(context.Background(), s, &Config{Sources: []Source{s}}, nil, nil, newProgressStore(""))
+	sub.indexStored(context.Background())
+
+	get := func(topic string) *JSONResponse {
+		r := httptest.NewRequest("GET", "/fe/r-question.json?max_year=28&min_year=28&season=haru&max_no=2&min_no=1&topic="+url.QueryEscape(topic), nil)
+		return sub.getRandom(context.Background(), r)
+	}
+	for i := 0; i < 5; i++ {
+		if jres := get("データベース"); jres.Error != "" || jres.Question != "db" {
+			t.Fatalf("question of the topic must be returned, got: %+v", jres)
+		}
+	}
+	if jres := get("セキュリティ"); jres.Error == "" {
+		t.Error("unknown topic must be error")
+	}
+}
+
+func TestGetSelectedParseRetry(t *testing.T) {
+	broken := src.Query{Year: 28, Season: src.SeasonSpring, No: 1}
+	db := src.Query{Year: 28, Season: src.SeasonSpring, No: 2}
+	s := localSource(t, map[src.Query]src.Response{
+		db: {Question: "db", Topic: []string{"データベース"}, Version: src.JSONVersion},
+	})
+	defer os.RemoveAll(s.Dir)
+	file := filepath.Join(s.Dir, src.LocalPath(broken)+src.ExtJSON)
+	if err := ioutil.WriteFile(file, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := newSubServer(context.Background(), s, &Config{Sources: []Source{s}, ParseRetry: 3}, nil, nil, newProgressStore(""))
+
+	both := src.QueryRange{MaxYear: 28, MinYear: 28, MaxNo: 2, MinNo: 1, Season: src.SeasonSpring}
+	for i := 0; i < 5; i++ {
+		q, res, err := sub.getSelected(context.Background(), both, "", ModeRandom, "", nil)
+		if err != nil || q.No != db.No || res.Question != "db" {
+			t.Fatalf("broken question must be skipped, got: %v, %+v, %v", q, res, err)
+		}
+	}
+	only := src.QueryRange{MaxYear: 28, MinYear: 28, MaxNo: 1, MinNo: 1, Season: src.SeasonSpring}
+	if _, _, err := sub.getSelected(context.Background(), only, "", ModeRandom, "", nil); !errors.Is(err, src.ErrParse) {
+		t.Errorf("parse error must be returned after the retries, got: %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	q := src.Query{Year: 28, Season: src.SeasonSpring, No: 3}
+	s := localSource(t, map[src.Query]src.Response{
+		q: {Question: "関係データベースの正規化", Version: src.JSONVersion},
+	})
+	defer os.RemoveAll(s.Dir)
+	sub := newSubServer(context.Background(), s, &Config{Sources: []Source{s}}, nil, nil, newProgressStore(""))
+	sub.indexStored(context.Background())
+
+	search := func(query string) SearchResponse {
+		w := httptest.NewRecorder()
+		sub.search(w, httptest.NewRequest("GET", "/fe/search.json?"+query, nil))
+		var res SearchResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res := search("q=" + url.QueryEscape("データベース"))
+	if res.Error != "" || res.Total != 1 || res.Indexed != 1 {
+		t.Fatalf("stored question must be found, got: %+v", res)
+	}
+	if h := res.Hits[0]; h.Year != q.Year || h.Season != q.Season || h.No != q.No || h.Snippet == "" {
+		t.Errorf("invalid hit, got: %+v", h)
+	}
+	if res := search("limit=5"); res.Error == "" {
+		t.Error("empty search text must be error")
+	}
+}
+
+func TestReviewSchedule(t *testing.T) {
+	now := time.Now()
+	item := &reviewItem{}
+	for i, want := range []int{1, 6, 15} {
+		item.update(true, now)
+		if item.Interval != want {
+			t.Errorf("%d: invalid interval after correct answer, got: %d, want: %d", i, item.Interval, want)
+		}
+	}
+	ef := item.EaseFactor
+	item.update(false, now)
+	if item.Interval != 1 || item.Repetition != 0 || item.EaseFactor >= ef {
+		t.Errorf("wrong answer must reset the schedule, got: %+v", item)
+	}
+
+	store := newProgressStore("")
+	wrong := src.Query{Year: 28, Season: src.SeasonSpring, No: 1}
+	right := src.Query{Year: 28, Season: src.SeasonSpring, No: 2}
+	store.record("user", "/fe", right, true)
+	store.record("user", "/fe", wrong, false)
+	qr := FESource.QueryRange
+
+	if qs := store.reviews("user", "/fe", qr, now); len(qs) != 0 {
+		t.Errorf("no question must be due now, got: %v", qs)
+	}
+	qs := store.reviews("user", "/fe", qr, now.AddDate(0, 0, 2))
+	if len(qs) != 2 || qs[0] != wrong {
+		t.Errorf("wrong question must be reviewed first, got: %v", qs)
+	}
+	if qs := store.reviews("other", "/fe", qr, now.AddDate(0, 0, 2)); len(qs) != 0 {
+		t.Errorf("other user must have no question to review, got: %v", qs)
+	}
+}
+
+func TestReviewOrder(t *testing.T) {
+	now := time.Now()
+	store := newProgressStore("")
+	item := func(no int, correct bool, due time.Time) *reviewItem {
+		return &reviewItem{
+			SubAddr:     "/fe",
+			Query:       src.Query{Year: 28, Season: src.SeasonSpring, No: no},
+			LastCorrect: correct,
+			Due:         due,
+		}
+	}
+	items := []*reviewItem{
+		item(1, true, now.AddDate(0, 0, -3)),
+		item(2, false, now.AddDate(0, 0, -1)),
+		item(3, true, now.AddDate(0, 0, -2)),
+		item(4, false, now.AddDate(0, 0, -2)),
+	}
+	store.users["user"] = make(map[string]*reviewItem)
+	for _, item := range items {
+		store.users["user"][reviewKey(item.SubAddr, item.Query)] = item
+	}
+
+	// wrong answers first even if due later, then earlier due first.
+	qs := store.reviews("user", "/fe", FESource.QueryRange, now)
+	for i, no := range []int{4, 2, 1, 3} {
+		if i >= len(qs) || qs[i].No != no {
+			t.Fatalf("invalid review order, got: %v, want No: 4, 2, 1, 3", qs)
+		}
+	}
+}
+
+func TestProgressStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feserver-progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "progress.json")
+
+	store := newProgressStore(file)
+	store.maxUsers = 2
+	q := src.Query{Year: 28, Season: src.SeasonSpring, No: 1}
+	store.record("expired", "/fe", q, true)
+	store.lastActive["expired"] = time.Now().Add(-progressLifeTime - time.Hour)
+	store.record("old", "/fe", q, true)
+	store.record("new", "/fe", q, true)
+	if _, ok := store.users["expired"]; ok {
+		t.Error("expired user must be removed")
+	}
+	store.record("newer", "/fe", q, true)
+	if _, ok := store.users["old"]; ok || len(store.users) != 2 {
+		t.Errorf("least recently active user must be removed over the limit, got: %v", store.users)
+	}
+
+	// the saving is delayed, save writes the file at once.
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("progress must not be saved before the delay, got: %v", err)
+	}
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := newProgressStore(file)
+	if len(loaded.users) != 2 || loaded.lastActive["newer"].IsZero() {
+		t.Errorf("saved progress must be loaded, got: %v", loaded.users)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// the source server answers nothing until the request is canceled.
+	arrived := make(chan struct{}, 1)
+	canceled := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-r.Context().Done()
+		canceled <- struct{}{}
+	}))
+	defer upstream.Close()
+
+	s := FESource
+	s.URL = upstream.URL + "/kakomon/{{.Year}}_{{.Season}}/q{{.No}}.html"
+	s.WaitSecond = 60
+	// the address in use, which can not be bound by the server.
+	used, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer used.Close()
+	server := New(&Config{HTTP: used.Addr().String(), Sources: []Source{s}})
+	sub := server.subServers[s.SubAddr]
+
+	// the fetch is canceled when the client goes away.
+	ctx, cancel := context.WithCancel(server.ctx)
+	r := httptest.NewRequest("GET", "/fe/question.json?year=28&season=haru&no=1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	go func() {
+		// the client goes away after the fetch has started.
+		<-arrived
+		cancel()
+	}()
+	sub.getQuestionJSON(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("canceled request must be unavailable, got status: %d", w.Code)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fetch must be canceled with the request")
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if server.ctx.Err() == nil {
+		t.Error("background works must be stopped after Shutdown")
+	}
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		t.Errorf("ListenAndServe after Shutdown must be ErrServerClosed without listening, got: %v", err)
+	}
+}
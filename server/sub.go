@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"time"
 
@@ -12,84 +14,241 @@ import (
 // subServer listens on the sub address of the top-level server,
 // and serves the questions from src.Source.
 type subServer struct {
-	getter   *src.Getter
-	source   Source
-	waitTime time.Duration
+	getter    *src.Getter
+	source    Source
+	waitTime  time.Duration
+	imageMode string
+	quizzes   *quizStore
+	progress  *progressStore
+	index     *src.Index
 }
 
-func newSubServer(s Source) *subServer {
-	return &subServer{
-		getter:   src.NewGetter(s.Source, src.LeastIntervalTime),
-		source:   s,
-		waitTime: time.Duration(s.WaitSecond) * time.Second,
+// the background works are stopped when ctx is canceled.
+func newSubServer(ctx context.Context, s Source, conf *Config, cache, imageCache src.Cache, progress *progressStore) *subServer {
+	opts := conf.GetterOptions()
+	if cache != nil {
+		opts = append(opts, src.WithCache(cache))
 	}
+	if imageCache != nil {
+		opts = append(opts, src.WithImageCache(imageCache))
+	}
+	if s.Dir != "" {
+		opts = append(opts, src.WithFetcher(src.NewLocalFetcher(s.Source, s.Dir)))
+	}
+	sub := &subServer{
+		getter:    src.NewGetter(s.Source, src.LeastIntervalTime, opts...),
+		source:    s,
+		waitTime:  time.Duration(s.WaitSecond) * time.Second,
+		imageMode: conf.Image,
+		quizzes:   newQuizStore(),
+		progress:  progress,
+		index:     src.NewIndex(),
+	}
+	go sub.indexStored(ctx)
+	if s.IndexURL != "" {
+		go sub.refreshSessions(ctx)
+	}
+	return sub
 }
 
-func (s *subServer) timeoutContext() (context.Context, context.CancelFunc) {
-	return context.WithTimeout(context.Background(), s.waitTime)
+// it returns the source definition with the current catalogue of the sessions.
+func (sub *subServer) currentSource() Source {
+	s := sub.source
+	s.Source = sub.getter.Source()
+	return s
 }
 
-func (server *subServer) getRandomQuestionJSON(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := server.timeoutContext()
-	defer cancel()
-
-	resCh := make(chan *JSONResponse, 1)
-	go func() {
-		defer close(resCh)
-		resCh <- server.getRandom(ctx, r)
-	}()
-
-	select {
-	case jres := <-resCh:
-		if err := jres.write(w); err != nil {
-			serverError(w, err, "Writing JSON Error. Check server log.", http.StatusInternalServerError)
+// it refreshes the catalogue of the sessions from the index page of the source,
+// at the start and every RefreshHour.
+func (sub *subServer) refreshSessions(ctx context.Context) {
+	for {
+		ss, err := sub.getter.UpdateSessions(ctx)
+		if err != nil {
+			// some sessions may be skipped, see src.DiscoverError.
+			log.Println("Error: refreshing sessions for " + sub.source.SubAddr + ": " + err.Error())
+		}
+		if len(ss) > 0 {
+			log.Printf("refreshed %d sessions for %q", len(ss), sub.source.SubAddr)
+		}
+		if sub.source.RefreshHour <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(sub.source.RefreshHour) * time.Hour):
 		}
-	case <-ctx.Done():
-		contextError(w, ctx)
 	}
 }
 
+// it returns the context for the request r, which is canceled
+// when the client goes away or the wait time is over.
+func (s *subServer) timeoutContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), s.waitTime)
+}
+
+// it writes JSONResponse to the client in some format.
+type responseWriter func(w http.ResponseWriter, jres *JSONResponse) error
+
+func writeJSONResponse(w http.ResponseWriter, jres *JSONResponse) error {
+	return jres.write(w)
+}
+
+// it returns the handler which gets the response by get within the wait time,
+// and writes it by write.
+func (server *subServer) handler(
+	get func(context.Context, *http.Request) *JSONResponse,
+	write responseWriter,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := server.timeoutContext(r)
+		defer cancel()
+
+		resCh := make(chan *JSONResponse, 1)
+		go func() {
+			defer close(resCh)
+			resCh <- get(ctx, r)
+		}()
+
+		select {
+		case jres := <-resCh:
+			if err := write(w, jres); err != nil {
+				serverError(w, err, "Writing Response Error. Check server log.", http.StatusInternalServerError)
+			}
+		case <-ctx.Done():
+			contextError(w, ctx, write)
+		}
+	}
+}
+
+func (server *subServer) getRandomQuestionJSON(w http.ResponseWriter, r *http.Request) {
+	server.handler(server.getRandom, writeJSONResponse)(w, r)
+}
+
 func (sub *subServer) getRandom(ctx context.Context, r *http.Request) *JSONResponse {
-	qr, err := parseGetRandomQuery(r.URL.Query(), sub.source)
+	qr, err := parseGetRandomQuery(r.URL.Query(), sub.currentSource())
+	if err != nil {
+		return &JSONResponse{Error: err.Error()}
+	}
+	mode, user, err := parseModeQuery(r.URL.Query())
 	if err != nil {
 		return &JSONResponse{Error: err.Error()}
 	}
-	res, err := sub.getter.GetRandom(ctx, qr)
+	_, res, err := sub.getSelected(ctx, qr, r.URL.Query().Get(QueryTopic), mode, user, nil)
 	if err != nil {
 		return &JSONResponse{Error: err.Error()}
 	}
+	sub.rewriteImages(ctx, &res)
 	return &JSONResponse{Response: res}
 }
 
-func (server *subServer) getQuestionJSON(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := server.timeoutContext()
-	defer cancel()
-
-	resCh := make(chan *JSONResponse, 1)
-	go func() {
-		defer close(resCh)
-		resCh <- server.getQuestion(ctx, r)
-	}()
-
-	select {
-	case jres := <-resCh:
-		if err := jres.write(w); err != nil {
-			serverError(w, err, "Writing JSON Error. Check server log.", http.StatusInternalServerError)
+// it gets the question selected by selectQuery, which is classified into the topic.
+// The topic is known after parsing the question, so that the question of the topic
+// is selected from the indexed questions, stored in the cache or the local dataset,
+// or served once, rather than fetching the questions until the topic matches.
+// empty topic matches any question.
+// The question which can not be parsed is skipped by the Getter,
+// up to ParseRetry times in the config.
+func (sub *subServer) getSelected(
+	ctx context.Context, qr src.QueryRange, topic, mode, user string, exclude map[src.Query]bool,
+) (src.Query, src.Response, error) {
+	var candidates []src.Query
+	if topic != "" {
+		source := sub.getter.Source()
+		for _, q := range sub.index.Topic(topic) {
+			if source.Contains(qr, q) {
+				candidates = append(candidates, q)
+			}
+		}
+		if len(candidates) == 0 {
+			return src.Query{}, src.Response{}, fmt.Errorf("no question of %s %s is stored", QueryTopic, topic)
 		}
-	case <-ctx.Done():
-		contextError(w, ctx)
 	}
+
+	tried := make(map[src.Query]bool, len(exclude))
+	for q := range exclude {
+		tried[q] = true
+	}
+	q, res, err := sub.getter.GetSelected(ctx, func(failed map[src.Query]error) (src.Query, error) {
+		for q, err := range failed {
+			if !tried[q] {
+				log.Println("Error: " + err.Error())
+				tried[q] = true
+			}
+		}
+		return sub.selectQuery(qr, candidates, mode, user, tried)
+	})
+	if err != nil {
+		return q, src.Response{}, err
+	}
+	sub.index.Add(q, res)
+	return q, res, nil
+}
+
+// it selects the query in the range by the mode, from the candidates
+// if they are given. The query in exclude is not selected as possible.
+func (sub *subServer) selectQuery(qr src.QueryRange, candidates []src.Query, mode, user string, exclude map[src.Query]bool) (src.Query, error) {
+	if mode == ModeReview {
+		for _, q := range sub.progress.reviews(user, sub.source.SubAddr, qr, time.Now()) {
+			// reviews does not know the categories of the source.
+			if !exclude[q] && sub.getter.Source().Contains(qr, q) && (candidates == nil || containsQuery(candidates, q)) {
+				return q, nil
+			}
+		}
+	}
+
+	if candidates != nil {
+		var rest []src.Query
+		for _, q := range candidates {
+			if !exclude[q] {
+				rest = append(rest, q)
+			}
+		}
+		if len(rest) == 0 {
+			rest = candidates
+		}
+		return rest[rand.Intn(len(rest))], nil
+	}
+
+	var q src.Query
+	for i := 0; i < retryUnique; i++ {
+		var err error
+		if q, err = sub.getter.RandomQuery(qr); err != nil {
+			return q, err
+		}
+		if !exclude[q] {
+			break
+		}
+	}
+	return q, nil
+}
+
+// the number of retries to select the query not excluded.
+const retryUnique = 10
+
+func containsQuery(qs []src.Query, q src.Query) bool {
+	for _, c := range qs {
+		if c == q {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *subServer) getQuestionJSON(w http.ResponseWriter, r *http.Request) {
+	server.handler(server.getQuestion, writeJSONResponse)(w, r)
 }
 
 func (sub *subServer) getQuestion(ctx context.Context, r *http.Request) *JSONResponse {
-	q, err := parseGetQuestionQuery(r.URL.Query(), sub.source)
+	q, err := parseGetQuestionQuery(r.URL.Query(), sub.currentSource())
 	if err != nil {
 		return &JSONResponse{Error: err.Error()}
 	}
-	res, err := sub.getter.Get(ctx, q)
+	res, err := sub.get(ctx, q)
 	if err != nil {
 		return &JSONResponse{Error: err.Error()}
 	}
+	sub.rewriteImages(ctx, &res)
 	return &JSONResponse{Response: res}
 }
 
@@ -98,15 +257,21 @@ func serverError(w http.ResponseWriter, err error, mes string, status int) {
 	http.Error(w, mes, status)
 }
 
-func contextError(w http.ResponseWriter, ctx context.Context) {
+func contextError(w http.ResponseWriter, ctx context.Context, write responseWriter) {
 	err := ctx.Err()
-	if err != context.DeadlineExceeded {
+	switch err {
+	case context.DeadlineExceeded:
+	case context.Canceled:
+		// the client went away, or the server is shutting down.
+		serverError(w, err, "Request canceled.", http.StatusServiceUnavailable)
+		return
+	default:
 		serverError(w, err, "Unknown error. Check server log", http.StatusInternalServerError)
 		return
 	}
 
 	jres := &JSONResponse{Error: "Request timeout. Please try again later."}
-	if err := jres.write(w); err != nil {
-		serverError(w, err, "Writing JSON Error. Check server log.", http.StatusInternalServerError)
+	if err := write(w, jres); err != nil {
+		serverError(w, err, "Writing Response Error. Check server log.", http.StatusInternalServerError)
 	}
 }
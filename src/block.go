@@ -0,0 +1,163 @@
+package src
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Block is a piece of the structured content in the question,
+// the selections and the explanation.
+type Block struct {
+	Type string `json:"type"` // BlockParagraph | BlockImage | BlockTable | BlockCode | BlockFormula
+
+	// text content for BlockParagraph, BlockCode and BlockFormula.
+	Text string `json:"text,omitempty"`
+
+	// absolute URL and alternative text for BlockImage.
+	Src string `json:"src,omitempty"`
+	Alt string `json:"alt,omitempty"`
+
+	// cells for BlockTable, row by row.
+	Rows [][]string `json:"rows,omitempty"`
+}
+
+// The types of the Block.
+const (
+	BlockParagraph = "paragraph"
+	BlockImage     = "image"
+	BlockTable     = "table"
+	BlockCode      = "code" // source code or pseudo-code.
+	BlockFormula   = "formula"
+)
+
+// Choice is a selection for the answer with structured content.
+type Choice struct {
+	Label       string  `json:"label"` // ア, イ, ウ or エ.
+	Content     []Block `json:"content"`
+	Explanation []Block `json:"explanation,omitempty"` // explanation for this selection.
+}
+
+// The labels of the selections.
+var choiceLabels = [...]string{"ア", "イ", "ウ", "エ"}
+
+// blockBuilder converts the HTML nodes into the ordered Blocks.
+type blockBuilder struct {
+	base   *url.URL // base URL to resolve the relative URL. may be nil.
+	blocks []Block
+	text   strings.Builder // pending paragraph text.
+}
+
+// parseBlocks returns the Blocks in the selection.
+// The relative URLs are resolved by base if not nil.
+func parseBlocks(s *goquery.Selection, base *url.URL) []Block {
+	b := &blockBuilder{base: base}
+	for _, n := range s.Nodes {
+		b.walk(n)
+	}
+	b.flush()
+	return b.blocks
+}
+
+// complete the pending paragraph.
+func (b *blockBuilder) flush() {
+	if text := strings.TrimSpace(b.text.String()); text != "" {
+		b.blocks = append(b.blocks, Block{Type: BlockParagraph, Text: text})
+	}
+	b.text.Reset()
+}
+
+func (b *blockBuilder) add(block Block) {
+	b.flush()
+	b.blocks = append(b.blocks, block)
+}
+
+func (b *blockBuilder) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		b.text.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		// handled below.
+	default:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			b.walk(c)
+		}
+		return
+	}
+
+	s := goquery.NewDocumentFromNode(n).Selection
+	switch {
+	case n.Data == "script" || n.Data == "style":
+		// not a content.
+	case n.Data == "br":
+		b.text.WriteString("\n")
+	case n.Data == "img":
+		src, _ := s.Attr("src")
+		alt, _ := s.Attr("alt")
+		b.add(Block{Type: BlockImage, Src: b.resolve(src), Alt: alt})
+	case n.Data == "table":
+		b.add(Block{Type: BlockTable, Rows: tableRows(s)})
+	case n.Data == "pre" || n.Data == "code" || hasClassPrefix(s, "pseudo", "code", "prog"):
+		b.add(Block{Type: BlockCode, Text: strings.Trim(s.Text(), "\n")})
+	case n.Data == "math" || hasClassPrefix(s, "formula", "siki", "math"):
+		b.add(Block{Type: BlockFormula, Text: strings.TrimSpace(s.Text())})
+	case isBlockElement(n.Data):
+		b.flush()
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			b.walk(c)
+		}
+		b.flush()
+	default:
+		// inline element continues the paragraph.
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			b.walk(c)
+		}
+	}
+}
+
+// returns the absolute URL of ref.
+func (b *blockBuilder) resolve(ref string) string {
+	if b.base == nil || ref == "" {
+		return ref
+	}
+	u, err := b.base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func tableRows(table *goquery.Selection) [][]string {
+	var rows [][]string
+	table.Find("tr").Each(func(_ int, tr *goquery.Selection) {
+		row := tr.Children().Filter("td, th").Map(func(_ int, cell *goquery.Selection) string {
+			return strings.TrimSpace(cell.Text())
+		})
+		rows = append(rows, row)
+	})
+	return rows
+}
+
+func isBlockElement(tag string) bool {
+	switch tag {
+	case "p", "div", "ul", "ol", "li", "dl", "dt", "dd", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote", "section":
+		return true
+	}
+	return false
+}
+
+// returns whether the element has a class starting with any of prefixes.
+func hasClassPrefix(s *goquery.Selection, prefixes ...string) bool {
+	class, _ := s.Attr("class")
+	for _, c := range strings.Fields(class) {
+		for _, p := range prefixes {
+			if strings.HasPrefix(c, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package src
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// The states of the circuit breaker.
+const (
+	// the requests are sent to the source server as usual.
+	BreakerClosed = "closed"
+	// the source server is regarded as down, and the requests fail fast
+	// with ErrUnavailable until the cooldown time passes.
+	BreakerOpen = "open"
+	// the cooldown time has passed, and a trial request is sent.
+	// the breaker is closed if it succeeds, otherwise opened again.
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStatus is the status of the circuit breaker for the source server.
+type BreakerStatus struct {
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`            // consecutive failures.
+	LastError string    `json:"lastError,omitempty"` // last failure.
+	OpenUntil time.Time `json:"openUntil,omitempty"` // end of the cooldown, for BreakerOpen.
+}
+
+// circuitBreaker stops requesting the source server for a while
+// after consecutive failures of threshold times.
+// It is safe for concurrent use.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // zero means the breaker is never opened.
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	lastErr  error
+	openedAt time.Time
+	trying   bool // a trial request is in flight for BreakerHalfOpen.
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// allow returns whether a request can be sent at now.
+// done must be called with the result of the allowed request.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && !now.Before(b.openedAt.Add(b.cooldown)) {
+		b.state = BreakerHalfOpen
+	}
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trying {
+			return false
+		}
+		b.trying = true
+	}
+	return true
+}
+
+// done records the result of the request allowed by allow.
+// ErrUnavailable is counted as the failure, and the other errors
+// such as the canceled request are not counted.
+func (b *circuitBreaker) done(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trying = false
+	switch {
+	case err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrRejected):
+		// the source server responded.
+		b.state, b.failures, b.lastErr = BreakerClosed, 0, nil
+	case errors.Is(err, ErrUnavailable):
+		b.failures++
+		b.lastErr = err
+		if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+			b.state, b.openedAt = BreakerOpen, now
+		}
+	}
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.lastErr != nil {
+		s.LastError = b.lastErr.Error()
+	}
+	if b.state == BreakerOpen {
+		s.OpenUntil = b.openedAt.Add(b.cooldown)
+	}
+	return s
+}
@@ -0,0 +1,399 @@
+package src
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is a interface for storing the results from the source server.
+// Getter consults it before accessing the source server so that
+// repeated requests for the same question are answered without network access.
+//
+// The implementation must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value stored with key.
+	// false is returned if the value is not found or expired.
+	Get(key string) ([]byte, bool)
+
+	// Put stores the value with key.
+	Put(key string, value []byte)
+
+	// Stats returns the statistics of the cache usage.
+	Stats() CacheStats
+}
+
+// Peeker is implemented by the Cache which can look up the value
+// without counting the hit or miss nor updating the order of the eviction.
+// Getter uses it to scan the cache, e.g. for indexing the stored questions.
+type Peeker interface {
+	// Peek returns the value stored with key as Get does.
+	Peek(key string) ([]byte, bool)
+}
+
+// it looks up the value by Peek if c implements Peeker, otherwise by Get.
+func peek(c Cache, key string) ([]byte, bool) {
+	if p, ok := c.(Peeker); ok {
+		return p.Peek(key)
+	}
+	return c.Get(key)
+}
+
+// CacheStats is the statistics of the cache usage.
+type CacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// cacheKey returns the key for the question specified by
+// the source URL template and Query.
+func cacheKey(s Source, q Query) string {
+	return fmt.Sprintf("%s#%s_%d", s.URL, q.session(), q.No)
+}
+
+// counter counts cache hits and misses.
+type counter struct {
+	hits, misses uint64
+}
+
+func (c *counter) count(hit bool) {
+	if hit {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
+
+func (c *counter) stats(entries int) CacheStats {
+	return CacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: entries,
+	}
+}
+
+// MemoryCache is the in-memory Cache with LRU eviction.
+type MemoryCache struct {
+	counter
+
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache returns new MemoryCache which holds
+// at most maxEntries values for ttl duration.
+// zero ttl means the values never expire.
+// it will panic if maxEntries is not positive.
+func NewMemoryCache(maxEntries int, ttl time.Duration) *MemoryCache {
+	if maxEntries <= 0 {
+		panic("src.NewMemoryCache: maxEntries must be > 0")
+	}
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element, maxEntries),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && expired(elem.Value.(*memoryEntry).expires) {
+		c.removeElement(elem)
+		ok = false
+	}
+	c.count(ok)
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*memoryEntry).value, true
+}
+
+func (c *MemoryCache) Peek(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok || expired(elem.Value.(*memoryEntry).expires) {
+		return nil, false
+	}
+	return elem.Value.(*memoryEntry).value, true
+}
+
+func (c *MemoryCache) Put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.ll.PushFront(&memoryEntry{
+		key:     key,
+		value:   value,
+		expires: expiresAt(c.ttl),
+	})
+	for c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *MemoryCache) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.entries, elem.Value.(*memoryEntry).key)
+}
+
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	n := c.ll.Len()
+	c.mu.Unlock()
+	return c.stats(n)
+}
+
+// FileCache is the on-disk Cache. Each value is stored as a file
+// under the directory, and the oldest files are removed when
+// the number of the files exceeds the limit.
+type FileCache struct {
+	counter
+
+	mu         sync.Mutex
+	dir        string
+	maxEntries int
+	ttl        time.Duration
+	modTimes   map[string]time.Time // file name -> modified time
+}
+
+// file extension for the FileCache entries.
+const fileCacheExt = ".cache"
+
+// NewFileCache returns new FileCache which stores the values under dir.
+// The directory is created if not exist, and the values already stored
+// are available.
+// It holds at most maxEntries values for ttl duration.
+// zero maxEntries means no limitation, and zero ttl means the values never expire.
+func NewFileCache(dir string, maxEntries int, ttl time.Duration) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	modTimes := make(map[string]time.Time, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() && filepath.Ext(info.Name()) == fileCacheExt {
+			modTimes[info.Name()] = info.ModTime()
+		}
+	}
+	c := &FileCache{
+		dir:        dir,
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		modTimes:   modTimes,
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func (c *FileCache) fileName(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:]) + fileCacheExt
+}
+
+func (c *FileCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := c.fileName(key)
+	modTime, ok := c.modTimes[name]
+	if ok && c.ttl > 0 && expired(modTime.Add(c.ttl)) {
+		c.remove(name)
+		ok = false
+	}
+	var value []byte
+	if ok {
+		var err error
+		if value, err = ioutil.ReadFile(filepath.Join(c.dir, name)); err != nil {
+			delete(c.modTimes, name)
+			ok = false
+		}
+	}
+	c.count(ok)
+	return value, ok
+}
+
+func (c *FileCache) Peek(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := c.fileName(key)
+	modTime, ok := c.modTimes[name]
+	if !ok || (c.ttl > 0 && expired(modTime.Add(c.ttl))) {
+		return nil, false
+	}
+	value, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *FileCache) Put(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := c.fileName(key)
+	if err := WriteFileAtomic(filepath.Join(c.dir, name), value); err != nil {
+		// the cache is optional. failure to store is not fatal.
+		return
+	}
+	c.modTimes[name] = time.Now()
+	c.evict()
+}
+
+// remove the oldest entries until the number of entries fits in maxEntries.
+// it must be called under the lock.
+func (c *FileCache) evict() {
+	if c.maxEntries <= 0 || len(c.modTimes) <= c.maxEntries {
+		return
+	}
+	names := make([]string, 0, len(c.modTimes))
+	for name := range c.modTimes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return c.modTimes[names[i]].Before(c.modTimes[names[j]])
+	})
+	for _, name := range names[:len(names)-c.maxEntries] {
+		c.remove(name)
+	}
+}
+
+func (c *FileCache) remove(name string) {
+	os.Remove(filepath.Join(c.dir, name))
+	delete(c.modTimes, name)
+}
+
+func (c *FileCache) Stats() CacheStats {
+	c.mu.Lock()
+	n := len(c.modTimes)
+	c.mu.Unlock()
+	return c.stats(n)
+}
+
+// TieredCache is the Cache composed by multiple caches.
+// The caches are looked up in order, and the value found in the later cache
+// is stored to the earlier caches. The value is stored to the all of the caches.
+//
+// For example, the in-memory cache in front of the on-disk cache:
+//
+//	c := src.NewTieredCache(memoryCache, fileCache)
+type TieredCache struct {
+	counter
+	caches []Cache
+}
+
+// NewTieredCache returns new TieredCache with caches.
+func NewTieredCache(caches ...Cache) *TieredCache {
+	return &TieredCache{caches: caches}
+}
+
+func (c *TieredCache) Get(key string) ([]byte, bool) {
+	for i, cache := range c.caches {
+		if value, ok := cache.Get(key); ok {
+			for _, upper := range c.caches[:i] {
+				upper.Put(key, value)
+			}
+			c.count(true)
+			return value, true
+		}
+	}
+	c.count(false)
+	return nil, false
+}
+
+// Peek looks up the caches in order, without storing
+// the value found to the earlier caches.
+func (c *TieredCache) Peek(key string) ([]byte, bool) {
+	for _, cache := range c.caches {
+		if value, ok := peek(cache, key); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func (c *TieredCache) Put(key string, value []byte) {
+	for _, cache := range c.caches {
+		cache.Put(key, value)
+	}
+}
+
+// Stats returns the statistics of the whole tiers.
+// Entries is the number of entries in the last tier.
+func (c *TieredCache) Stats() CacheStats {
+	entries := 0
+	if n := len(c.caches); n > 0 {
+		entries = c.caches[n-1].Stats().Entries
+	}
+	return c.stats(entries)
+}
+
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func expired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+// WriteFileAtomic writes data to the temporary file and renames it to file,
+// so that the readers never see partially written file. The data is flushed
+// to the disk before renaming, so that the file is not lost by the crash.
+func WriteFileAtomic(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,99 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheLRU(t *testing.T) {
+	c := NewMemoryCache(2, 0)
+	c.Put("a", []byte("A"))
+	c.Put("b", []byte("B"))
+	c.Get("a") // "b" becomes least recently used.
+	c.Put("c", []byte("C"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry must be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("entry %q must be cached", key)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 3 || stats.Misses != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	c := NewMemoryCache(10, time.Millisecond)
+	c.Put("a", []byte("A"))
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Error("expired entry must not be returned")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feserver-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewFileCache(dir, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("a", []byte("A"))
+	time.Sleep(10 * time.Millisecond) // makes modified time distinct.
+	c.Put("b", []byte("B"))
+	time.Sleep(10 * time.Millisecond)
+	c.Put("c", []byte("C"))
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("oldest entry must be evicted")
+	}
+
+	// reopen and the stored entries are still available.
+	c, err = NewFileCache(dir, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Get("c"); !ok || string(v) != "C" {
+		t.Errorf("stored entry must be available after reopen, got: %q", v)
+	}
+}
+
+func TestTieredCache(t *testing.T) {
+	upper, lower := NewMemoryCache(10, 0), NewMemoryCache(10, 0)
+	lower.Put("a", []byte("A"))
+
+	c := NewTieredCache(upper, lower)
+	if v, ok := c.Get("a"); !ok || string(v) != "A" {
+		t.Fatalf("entry in lower tier must be found, got: %q", v)
+	}
+	if _, ok := upper.Get("a"); !ok {
+		t.Error("entry found in lower tier must be stored to upper tier")
+	}
+
+	// Peek does not change the statistics nor the tiers.
+	lower.Put("b", []byte("B"))
+	before := c.Stats()
+	if v, ok := c.Peek("b"); !ok || string(v) != "B" {
+		t.Fatalf("entry in lower tier must be peeked, got: %q", v)
+	}
+	if _, ok := c.Peek("c"); ok {
+		t.Error("missing entry must not be peeked")
+	}
+	if after := c.Stats(); after != before {
+		t.Errorf("Peek must not change the statistics, got: %+v, want: %+v", after, before)
+	}
+	if _, ok := upper.Peek("b"); ok {
+		t.Error("peeked entry must not be stored to upper tier")
+	}
+}
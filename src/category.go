@@ -0,0 +1,105 @@
+package src
+
+import "fmt"
+
+// Category names of the questions in the morning examinations.
+const (
+	CategoryTechnology = "テクノロジ系"
+	CategoryManagement = "マネジメント系"
+	CategoryStrategy   = "ストラテジ系"
+)
+
+// Category maps the question numbers to the category and its field.
+// The question numbers in [MinNo:MaxNo] belong to the category.
+// A category may be split into several fields by the multiple Categories
+// having the same Name.
+type Category struct {
+	Name  string // e.g. CategoryTechnology.
+	Field string // sub-field in the category, e.g. "基礎理論". optional.
+
+	MinNo, MaxNo int
+}
+
+// it returns whether the question number belongs to the category.
+func (c Category) has(no int) bool {
+	return c.MinNo <= no && no <= c.MaxNo
+}
+
+// it returns whether the category matches the name of the category or its field.
+func (c Category) matches(name string) bool {
+	return name == c.Name || (c.Field != "" && name == c.Field)
+}
+
+// the default Categories for the morning examinations having 80 questions,
+// F.E. and A.P.
+var defaultCategories = []Category{
+	{Name: CategoryTechnology, MinNo: 1, MaxNo: 50},
+	{Name: CategoryManagement, MinNo: 51, MaxNo: 60},
+	{Name: CategoryStrategy, MinNo: 61, MaxNo: 80},
+}
+
+// CategoryOf returns the Category for the question number.
+// false is returned if the source has no Category for the number.
+func (src Source) CategoryOf(no int) (Category, bool) {
+	for _, c := range src.Categories {
+		if c.has(no) {
+			return c, true
+		}
+	}
+	return Category{}, false
+}
+
+// HasCategory returns whether the source has the category or field named name.
+func (src Source) HasCategory(name string) bool {
+	for _, c := range src.Categories {
+		if c.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns whether the query is in the range, taking account of
+// the range's Category. It is same as qr.Contains if qr.Category is empty.
+func (src Source) Contains(qr QueryRange, q Query) bool {
+	if !qr.Contains(q) {
+		return false
+	}
+	if qr.Category == "" {
+		return true
+	}
+	c, ok := src.CategoryOf(q.No)
+	return ok && c.matches(qr.Category)
+}
+
+// it returns the question numbers in [qr.MinNo:qr.MaxNo]
+// belonging to qr.Category if given, in ascending order.
+func (src Source) numbers(qr QueryRange) []int {
+	var nos []int
+	for no := qr.MinNo; no <= qr.MaxNo; no++ {
+		if qr.Category == "" {
+			nos = append(nos, no)
+		} else if c, ok := src.CategoryOf(no); ok && c.matches(qr.Category) {
+			nos = append(nos, no)
+		}
+	}
+	return nos
+}
+
+// check whether the Categories have correct values.
+func validatesCategories(cs []Category) error {
+	for i, c := range cs {
+		if c.Name == "" {
+			return fmt.Errorf("Source: Categories[%d] must have Name", i)
+		}
+		if c.MaxNo < c.MinNo {
+			return fmt.Errorf("Source: Categories[%d] MaxNo must be larger then MinNo but Max: %d, Min: %d", i, c.MaxNo, c.MinNo)
+		}
+		for j, other := range cs[:i] {
+			if c.MinNo <= other.MaxNo && other.MinNo <= c.MaxNo {
+				return fmt.Errorf("Source: Categories[%d] overlaps Categories[%d]", i, j)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package src
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// it returns the encoding by the name in Source.Encoding.
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("Source: unknown Encoding %s", name)
+	}
+	return enc, nil
+}
+
+// it returns the encoding of the html page.
+// The encoding named by override is used if given, otherwise it is detected
+// from the BOM, the charset in contentType, the meta element in the page,
+// and the content itself in this order. See guessJapanese for the content.
+func detectEncoding(body []byte, contentType, override string) (encoding.Encoding, error) {
+	if override != "" {
+		return lookupEncoding(override)
+	}
+	// the fallbacks of DetermineEncoding, which are not declared by the page,
+	// are replaced by the guess from the whole content.
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if certain || (name != "windows-1252" && name != "utf-8") {
+		return enc, nil
+	}
+	return guessJapanese(body), nil
+}
+
+// it guesses the Japanese encoding of the content without the declaration.
+// The content is UTF-8 if it is valid as UTF-8, EUC-JP if all of the
+// multibyte characters fit in EUC-JP, otherwise ShiftJIS.
+func guessJapanese(body []byte) encoding.Encoding {
+	switch {
+	case utf8.Valid(body):
+		return unicode.UTF8
+	case isEUCJP(body):
+		return japanese.EUCJP
+	default:
+		return japanese.ShiftJIS
+	}
+}
+
+func isEUCJP(b []byte) bool {
+	eucByte := func(i int) bool { return i < len(b) && b[i] >= 0xa1 && b[i] <= 0xfe }
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c < 0x80:
+		case c == 0x8e: // half-width katakana.
+			if i+1 >= len(b) || b[i+1] < 0xa1 || b[i+1] > 0xdf {
+				return false
+			}
+			i++
+		case c == 0x8f: // JIS X 0212.
+			if !eucByte(i+1) || !eucByte(i+2) {
+				return false
+			}
+			i += 2
+		case eucByte(i):
+			if !eucByte(i + 1) {
+				return false
+			}
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// the directory name of the session in the index page, such as
+// "28_haru", "r01_aki", "02_oct" and "05_koukai".
+var sessionDirPattern = regexp.MustCompile(`^(r)?(\d{2})_([a-z]+)$`)
+
+// the maximum question number to be searched in the session page.
+const maxDiscoverNo = 200
+
+// DiscoverError is returned by DiscoverSessions together with the sessions found,
+// when the questions of some sessions can not be counted.
+type DiscoverError struct {
+	Failures map[string]error // the page of the skipped session -> the error.
+}
+
+func (e *DiscoverError) Error() string {
+	links := make([]string, 0, len(e.Failures))
+	for link := range e.Failures {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	for i, link := range links {
+		links[i] = link + ": " + e.Failures[link].Error()
+	}
+	return fmt.Sprintf("DiscoverSessions: %d sessions are skipped: %s", len(links), strings.Join(links, "; "))
+}
+
+// DiscoverSessions fetches the index page of the source, Source.IndexURL,
+// and returns the catalogue of the sessions listed in it.
+// The number of the questions is counted by fetching the page of each session,
+// except for the sessions in known whose numbers are reused.
+// The interval wait time is inserted between the requests to the same host.
+//
+// The session whose questions can not be counted is skipped, and
+// DiscoverError is returned with the other sessions.
+func (g *Getter) DiscoverSessions(ctx context.Context, known []Session) ([]Session, error) {
+	src := g.url.source()
+	if src.IndexURL == "" {
+		return nil, fmt.Errorf("DiscoverSessions: Source has no IndexURL")
+	}
+	doc, err := g.fetchDocument(ctx, src.IndexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[Query]string) // session -> its page.
+	now := time.Now()
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		// the sessions in the other site are not followed.
+		link, ok := resolveLink(doc.Url, a)
+		if !ok || link.Host != doc.Url.Host {
+			return
+		}
+		dir := strings.TrimSuffix(link.Path, "index.html")
+		if q, ok := parseSessionDir(path.Base(strings.TrimSuffix(dir, "/")), now); ok {
+			if _, dup := links[q]; !dup {
+				links[q] = link.String()
+			}
+		}
+	})
+
+	var sessions []Session
+	failures := make(map[string]error)
+	for q, link := range links {
+		s := Session{Era: q.Era, Year: q.Year, Season: q.Season}
+		if k, ok := (Source{Sessions: known}).session(q); ok && k.MaxNo > 0 {
+			s.MaxNo = k.MaxNo
+		} else if s.MaxNo, err = g.countQuestions(ctx, link, q); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			failures[link] = err
+			continue
+		}
+		if s.MaxNo > 0 {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i].query(0), sessions[j].query(0)
+		if ya, yb := a.WesternYear(), b.WesternYear(); ya != yb {
+			return ya < yb
+		}
+		return a.Season < b.Season
+	})
+	if len(failures) > 0 {
+		return sessions, &DiscoverError{Failures: failures}
+	}
+	return sessions, nil
+}
+
+// UpdateSessions discovers the sessions by DiscoverSessions, and replaces
+// the catalogue of the Getter's source with them.
+// The year and number range of the source is extended to cover the catalogue.
+// The catalogue is replaced even if DiscoverError is returned for the skipped sessions.
+func (g *Getter) UpdateSessions(ctx context.Context) ([]Session, error) {
+	sessions, err := g.DiscoverSessions(ctx, g.url.source().Sessions)
+	var derr *DiscoverError
+	if err != nil && !errors.As(err, &derr) {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("DiscoverSessions: no session is found in %s", g.url.source().IndexURL)
+	}
+	if err := g.url.setSessions(sessions); err != nil {
+		return nil, err
+	}
+	return sessions, err
+}
+
+// Source returns the current source definition of the Getter,
+// which may be updated by UpdateSessions.
+func (g *Getter) Source() Source {
+	return g.url.source()
+}
+
+// it returns the session for the directory name in the index page.
+// The year without era is Reiwa if it is not over the current Reiwa year,
+// otherwise Heisei, e.g. "05" is Reiwa 5 and "28" is Heisei 28.
+func parseSessionDir(dir string, now time.Time) (Query, bool) {
+	m := sessionDirPattern.FindStringSubmatch(dir)
+	if m == nil {
+		return Query{}, false
+	}
+	year, _ := strconv.Atoi(m[2])
+	q := Query{Era: EraHeisei, Year: year, Season: m[3]}
+	if m[1] == EraReiwa || year <= now.Year()-eraFirstYears[EraReiwa]+1 {
+		q.Era = EraReiwa
+	}
+	return q, q.validatesEra() == nil
+}
+
+// it returns the last question number linked from the session page.
+// zero is returned if no question is linked.
+func (g *Getter) countQuestions(ctx context.Context, link string, q Query) (int, error) {
+	doc, err := g.fetchDocument(ctx, link)
+	if err != nil {
+		return 0, err
+	}
+	// the question URLs rendered by the template.
+	nos := make(map[string]int, maxDiscoverNo)
+	for no := 1; no <= maxDiscoverNo; no++ {
+		q.No = no
+		u, err := g.url.render(q)
+		if err != nil {
+			return 0, err
+		}
+		nos[u] = no
+	}
+	max := 0
+	doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+		if u, ok := resolveLink(doc.Url, a); ok {
+			u.Fragment = ""
+			if no := nos[u.String()]; no > max {
+				max = no
+			}
+		}
+	})
+	return max, nil
+}
+
+func (g *Getter) fetchDocument(ctx context.Context, url string) (*goquery.Document, error) {
+	page, err := g.upstream.get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := detectEncoding(page.Body, page.ContentType, g.url.source().Encoding)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := newDocument(page.Body, enc)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Url, err = neturl.Parse(url); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func resolveLink(base *neturl.URL, a *goquery.Selection) (*neturl.URL, bool) {
+	href, _ := a.Attr("href")
+	u, err := base.Parse(href)
+	if err != nil {
+		return nil, false
+	}
+	return u, true
+}
@@ -0,0 +1,216 @@
+package src
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// Japanese eras for Query.Era.
+// empty Era is treated as EraHeisei for the compatibility.
+const (
+	EraHeisei = "h" // 平成, 1989 - April 2019.
+	EraReiwa  = "r" // 令和, May 2019 -.
+)
+
+// the first year of the era in the Western calendar.
+var eraFirstYears = map[string]int{
+	EraHeisei: 1989,
+	EraReiwa:  2019,
+}
+
+const (
+	// the years in QueryRange are Western years if they are not less than it,
+	// otherwise Heisei years.
+	FirstWesternYear = 1989
+
+	// the last year of Heisei. The spring session of 2019 was in Heisei,
+	// and the autumn session in Reiwa.
+	lastHeiseiYear = 31
+)
+
+// Special sessions held other than spring and autumn.
+const (
+	// October 2020, held instead of the autumn session.
+	SeasonOctober = "oct"
+)
+
+// irregularSessions are the sessions which were not held as usual.
+// the empty replacement means the session was cancelled.
+// The spring session of 2020 was cancelled, and the autumn session
+// was held in October.
+var irregularSessions = map[yearSeason]string{
+	{2020, SeasonSpring}: "",
+	{2020, SeasonAutumn}: SeasonOctober,
+}
+
+type yearSeason struct {
+	year   int // Western year.
+	season string
+}
+
+// it returns the regular season which the special session replaces.
+func regularSeason(season string) string {
+	if season == SeasonOctober {
+		return SeasonAutumn
+	}
+	return season
+}
+
+// WesternQuery returns the Query for the session of the Western year and
+// the regular season, taking account of the era and the irregular sessions.
+// false is returned if the session was not held.
+func WesternQuery(year int, season string, no int) (Query, bool) {
+	if s, ok := irregularSessions[yearSeason{year, season}]; ok {
+		if s == "" {
+			return Query{}, false
+		}
+		season = s
+	}
+	era, eraYear := eraOf(year, season)
+	return Query{Era: era, Year: eraYear, Season: season, No: no}, true
+}
+
+// it returns the era and the year in the era for the session.
+func eraOf(year int, season string) (string, int) {
+	if first := eraFirstYears[EraReiwa]; year > first || (year == first && regularSeason(season) != SeasonSpring) {
+		return EraReiwa, year - first + 1
+	}
+	return EraHeisei, year - eraFirstYears[EraHeisei] + 1
+}
+
+// Normalize returns the query with the explicit era.
+// The year not less than FirstWesternYear is treated as the Western year,
+// and converted into the year in the era.
+func (q Query) Normalize() Query {
+	if q.Year >= FirstWesternYear {
+		q.Era, q.Year = eraOf(q.Year, q.Season)
+	} else if q.Era == "" {
+		q.Era = EraHeisei
+	}
+	return q
+}
+
+func (q Query) era() string {
+	if q.Era == "" {
+		return EraHeisei
+	}
+	return q.Era
+}
+
+// WesternYear returns the year of the session in the Western calendar.
+// zero is returned for the unknown era.
+func (q Query) WesternYear() int {
+	first, ok := eraFirstYears[q.era()]
+	if !ok {
+		return 0
+	}
+	return first + q.Year - 1
+}
+
+// it returns the name of the session, such as "28_haru", "r01_aki" and "r02_oct".
+// The name of the Heisei session has no era for the compatibility.
+func (q Query) session() string {
+	if q.era() == EraHeisei {
+		return fmt.Sprintf("%02d_%s", q.Year, q.Season)
+	}
+	return fmt.Sprintf("%s%02d_%s", q.era(), q.Year, q.Season)
+}
+
+// it checks the era, year and season of the query
+// taking account of the era boundary and the irregular sessions.
+func (q Query) validatesSession() error {
+	if err := q.validatesEra(); err != nil {
+		return err
+	}
+
+	year, season := q.WesternYear(), regularSeason(q.Season)
+	switch {
+	case q.Season != SeasonSpring && q.Season != SeasonAutumn && q.Season != SeasonOctober:
+		return fmt.Errorf("Query: Season must be either %s, %s or %s, but %s", SeasonSpring, SeasonAutumn, SeasonOctober, q.Season)
+	case q.Season != season:
+		// special session
+		if s := irregularSessions[yearSeason{year, season}]; s != q.Season {
+			return fmt.Errorf("Query: the session %s was not held", q.session())
+		}
+	default:
+		if s, ok := irregularSessions[yearSeason{year, season}]; ok {
+			if s == "" {
+				return fmt.Errorf("Query: the session %s was cancelled", q.session())
+			}
+			return fmt.Errorf("Query: the session %s was held as %s", q.session(), s)
+		}
+	}
+	return nil
+}
+
+// it checks the era and year of the query taking account of the era boundary.
+func (q Query) validatesEra() error {
+	switch q.era() {
+	case EraHeisei:
+		if q.Year < 1 || q.Year > lastHeiseiYear {
+			return fmt.Errorf("Query: Heisei year must be in [1:%d], but %d", lastHeiseiYear, q.Year)
+		}
+		if q.Year == lastHeiseiYear && q.Season != SeasonSpring {
+			return fmt.Errorf("Query: Heisei %d has %s session only, use Reiwa 1 insteadly", lastHeiseiYear, SeasonSpring)
+		}
+	case EraReiwa:
+		if q.Year < 1 {
+			return fmt.Errorf("Query: Reiwa year must be positive, but %d", q.Year)
+		}
+		if q.Year == 1 && q.Season == SeasonSpring {
+			return fmt.Errorf("Query: Reiwa 1 has no %s session, use Heisei %d insteadly", SeasonSpring, lastHeiseiYear)
+		}
+	default:
+		return fmt.Errorf("Query: Era must be either %s or %s, but %s", EraHeisei, EraReiwa, q.Era)
+	}
+	return nil
+}
+
+// it returns the Western year of the year in QueryRange.
+func westernYear(year int) int {
+	if year >= FirstWesternYear {
+		return year
+	}
+	return year + eraFirstYears[EraHeisei] - 1
+}
+
+// it checks the year in QueryRange is the Heisei year or the Western year.
+func validatesYear(year int) error {
+	if year >= FirstWesternYear || (year >= 1 && year <= lastHeiseiYear) {
+		return nil
+	}
+	return fmt.Errorf("year must be either Heisei year in [1:%d] or Western year since %d, but %d",
+		lastHeiseiYear, FirstWesternYear, year)
+}
+
+// the months in which the questions of the session are published.
+var publishedMonths = map[string]time.Month{
+	SeasonSpring:  time.May,
+	SeasonAutumn:  time.November,
+	SeasonOctober: time.November,
+}
+
+// it returns whether the questions of the session are published at now.
+func (q Query) published(now time.Time) bool {
+	return !now.Before(time.Date(q.WesternYear(), publishedMonths[q.Season], 1, 0, 0, 0, 0, time.Local))
+}
+
+// the functions for the URL template of Source, each of which
+// accepts the Query. e.g. "{{sikenYear .}}_{{.Season}}/q{{.No}}.html".
+var urlFuncs = template.FuncMap{
+	// the era, "h" or "r".
+	"era": func(q Query) string { return q.era() },
+	// the year in the Western calendar, e.g. 2019.
+	"western": func(q Query) int { return q.WesternYear() },
+	// the 2-digit year in the era, e.g. "28", "01".
+	"year2": func(q Query) string { return fmt.Sprintf("%02d", q.Year) },
+	// the year in the paths of *-siken.com, e.g. "28", "r01" and "05".
+	// the first year of Reiwa has the era to be distinguished from Heisei.
+	"sikenYear": func(q Query) string {
+		if q.era() == EraReiwa && q.Year == 1 {
+			return fmt.Sprintf("%s%02d", EraReiwa, q.Year)
+		}
+		return fmt.Sprintf("%02d", q.Year)
+	},
+}
@@ -0,0 +1,80 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of the errors on getting the questions.
+// The errors returned by the Getter can be tested by errors.Is, e.g.
+// errors.Is(err, ErrNotFound).
+var (
+	// the page does not exist in the source server, e.g. 404 status.
+	ErrNotFound = errors.New("not found")
+	// the source server is not available for now, e.g. 5xx status,
+	// the network failure or the open circuit breaker. retrying later may succeed.
+	ErrUnavailable = errors.New("upstream unavailable")
+	// the request is refused by the source server, e.g. 400 or 403 status.
+	// retrying the same request does not succeed.
+	ErrRejected = errors.New("request rejected")
+	// the page can not be parsed as the question.
+	ErrParse = errors.New("parse failure")
+)
+
+// ErrCircuitOpen is the cause of ErrUnavailable returned without
+// requesting while the circuit breaker for the source is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// FetchError is the error on fetching the page from the source server.
+// It is either ErrNotFound, ErrUnavailable or ErrRejected.
+type FetchError struct {
+	Kind       error  // ErrNotFound | ErrUnavailable | ErrRejected
+	URL        string // requested URL.
+	StatusCode int    // HTTP status code. zero if no response is received.
+	Err        error  // the cause. nil for the bad status code.
+}
+
+func (e *FetchError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("Fetch %s: %v: %v", e.URL, e.Kind, e.Err)
+	case e.StatusCode != 0:
+		return fmt.Sprintf("Fetch %s: %v: status %d", e.URL, e.Kind, e.StatusCode)
+	default:
+		return fmt.Sprintf("Fetch %s: %v", e.URL, e.Kind)
+	}
+}
+
+func (e *FetchError) Unwrap() error { return e.Err }
+
+// Is reports whether the error is the kind of target.
+func (e *FetchError) Is(target error) bool { return target == e.Kind }
+
+// it returns whether retrying the request may succeed.
+func (e *FetchError) temporary() bool {
+	return e.Kind == ErrUnavailable && e.Err != ErrCircuitOpen
+}
+
+// ParseError is the error on parsing the page of the question.
+// It is ErrParse.
+type ParseError struct {
+	URL      string // source URL of the page. empty if unknown.
+	Selector string // CSS selector of the missing or invalid part. empty if unknown.
+	Err      error  // the cause.
+}
+
+func (e *ParseError) Error() string {
+	mes := "Parse"
+	if e.URL != "" {
+		mes += " " + e.URL
+	}
+	if e.Selector != "" {
+		mes += fmt.Sprintf(": selector %q", e.Selector)
+	}
+	return fmt.Sprintf("%s: %v", mes, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrParse.
+func (e *ParseError) Is(target error) bool { return target == ErrParse }
@@ -0,0 +1,220 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Fetcher fetches the raw page of the question specified by Query.
+// The page is fetched from the source server by default,
+// and can be fetched from the other location such as the local directory.
+type Fetcher interface {
+	Fetch(ctx context.Context, q Query) (Page, error)
+}
+
+// Page is the raw content for the question.
+type Page struct {
+	URL  string // source URL of the question.
+	Body []byte
+	Type string // PageHTML | PageJSON
+
+	// Content-Type header as served. empty if unknown.
+	ContentType string
+}
+
+// The types of the Page content.
+const (
+	PageHTML = "html" // web page of the source server.
+	PageJSON = "json" // Response encoded by json.
+)
+
+// parsePage() returns Response parsed from the page.
+// The html page is decoded from the encoding named by override,
+// or detected if it is empty, and parsed by parser.
+func parsePage(p Page, parser Parser, override string) (Response, error) {
+	if p.Type == PageJSON {
+		var res Response
+		if err := json.Unmarshal(p.Body, &res); err != nil {
+			return Response{}, err
+		}
+		return res, nil
+	}
+	enc, err := detectEncoding(p.Body, p.ContentType, override)
+	if err != nil {
+		return Response{}, err
+	}
+	return parseHTML(p.URL, p.Body, parser, enc)
+}
+
+// httpFetcher fetches the page from the source server.
+// It is safe for concurrent use.
+type httpFetcher struct {
+	url *urlGenerator
+
+	client *http.Client
+	header http.Header
+
+	intervalTime time.Duration
+	limiter      *RateLimiter // nil means the limiter shared by the host.
+
+	retries   int
+	retryWait time.Duration
+	breaker   *circuitBreaker
+}
+
+func newHTTPFetcher(url *urlGenerator, intervalTime time.Duration, opts httpOptions) *httpFetcher {
+	return &httpFetcher{
+		url:          url,
+		client:       opts.newClient(),
+		header:       opts.header,
+		intervalTime: intervalTime,
+		limiter:      opts.limiter,
+		retries:      opts.retries,
+		retryWait:    opts.retryWait,
+		breaker:      newCircuitBreaker(opts.breakerThreshold, opts.breakerCooldown),
+	}
+}
+
+// To reduce the frequent request for the server,
+// wait interval time between the requests to the same host.
+func (f *httpFetcher) wait(ctx context.Context, url string) error {
+	l := f.limiter
+	if l == nil {
+		l = hostLimiter(hostOf(url), f.intervalTime)
+	}
+	return l.Wait(ctx)
+}
+
+// Fetch returns the page from the source server.
+// The interval wait time is inserted between the requests to the same host.
+func (f *httpFetcher) Fetch(ctx context.Context, q Query) (Page, error) {
+	url, err := f.url.Generate(q)
+	if err != nil {
+		return Page{}, err
+	}
+	return f.get(ctx, url)
+}
+
+// get() returns the page at the url through the circuit breaker.
+// The interval wait time is inserted before every request, and
+// the temporary failure is retried with the exponential backoff
+// as long as the deadline of ctx allows.
+func (f *httpFetcher) get(ctx context.Context, url string) (Page, error) {
+	return f.through(ctx, url, true)
+}
+
+// getResource() returns the resource such as the image at the url as get() does,
+// but without the interval wait time. The resources must belong to the page
+// already requested, and are requested together with it as the web browser does.
+func (f *httpFetcher) getResource(ctx context.Context, url string) (Page, error) {
+	return f.through(ctx, url, false)
+}
+
+func (f *httpFetcher) through(ctx context.Context, url string, limited bool) (Page, error) {
+	if !f.breaker.allow(time.Now()) {
+		return Page{}, &FetchError{Kind: ErrUnavailable, URL: url, Err: ErrCircuitOpen}
+	}
+	page, err := f.retry(ctx, url, limited)
+	f.breaker.done(err, time.Now())
+	return page, err
+}
+
+func (f *httpFetcher) retry(ctx context.Context, url string, limited bool) (Page, error) {
+	backoff := f.retryWait
+	for i := 0; ; i++ {
+		if limited {
+			if err := f.wait(ctx, url); err != nil {
+				return Page{}, err
+			}
+		}
+		page, err := f.fetchURL(ctx, url)
+		var ferr *FetchError
+		if err == nil || i >= f.retries || !errors.As(err, &ferr) || !ferr.temporary() {
+			return page, err
+		}
+
+		// jitter in [0, backoff/2) avoids the retries at once.
+		wait := backoff
+		if half := int64(backoff / 2); half > 0 {
+			randMutex.Lock()
+			wait += time.Duration(random.Int63n(half))
+			randMutex.Unlock()
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return Page{}, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Page{}, err
+		}
+		backoff *= 2
+	}
+}
+
+// ErrTooLarge is the cause of ErrNotFound for the content larger than
+// maxBodySize, which is neither the question nor the image in it.
+var ErrTooLarge = errors.New("content too large")
+
+// the maximum size of the content read from the source server,
+// so that a huge content is not held in memory.
+const maxBodySize = 10 << 20
+
+// fetchURL() returns raw content of the url as served, with its Content-Type.
+// It is also used for the other resources such as the images.
+// The request is aborted when ctx is canceled.
+//
+// The error is FetchError for the status other than 2xx, the content larger
+// than maxBodySize and the network error, or ctx.Err() if ctx is done.
+func (f *httpFetcher) fetchURL(ctx context.Context, url string) (Page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Page{}, err
+	}
+	for key, values := range f.header {
+		req.Header[key] = values
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Page{}, ctx.Err()
+		}
+		return Page{}, &FetchError{Kind: ErrUnavailable, URL: url, Err: err}
+	}
+	defer res.Body.Close()
+
+	switch code := res.StatusCode; {
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return Page{}, &FetchError{Kind: ErrNotFound, URL: url, StatusCode: code}
+	case code == http.StatusTooManyRequests || code >= 500:
+		return Page{}, &FetchError{Kind: ErrUnavailable, URL: url, StatusCode: code}
+	case code < 200 || code >= 300:
+		// the other client errors are not fixed by retrying,
+		// but the page may exist.
+		return Page{}, &FetchError{Kind: ErrRejected, URL: url, StatusCode: code}
+	}
+
+	if res.ContentLength > maxBodySize {
+		return Page{}, &FetchError{Kind: ErrNotFound, URL: url, Err: ErrTooLarge}
+	}
+	// read a byte over the limit to know the content exceeds it.
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxBodySize+1))
+	if err != nil {
+		if ctx.Err() != nil {
+			return Page{}, ctx.Err()
+		}
+		return Page{}, &FetchError{Kind: ErrUnavailable, URL: url, Err: err}
+	}
+	if len(body) > maxBodySize {
+		return Page{}, &FetchError{Kind: ErrNotFound, URL: url, Err: ErrTooLarge}
+	}
+	return Page{URL: url, Body: body, Type: PageHTML, ContentType: res.Header.Get("Content-Type")}, nil
+}
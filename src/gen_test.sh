@@ -7,18 +7,15 @@ else
 	echo "wget not found. please install that" && exit 1
 fi
 
-if `type nkf > /dev/null 2>&1`; then 
-	echo "nkf found"
-else
-	echo "nkf not found. please install that" && exit 1
-fi
+# get the real pages as the golden fixtures. the pages are stored as served, in ShiftJIS.
+# check in the pages and the golden files, so that the layout changes of the sites are caught.
+wget -O ./testdata/golden/fe/y28_spring_q2.html -A html http://www.fe-siken.com/kakomon/28_haru/q2.html 
+
+wget -O ./testdata/golden/fe/y19_spring_q26.html -A html http://www.fe-siken.com/kakomon/19_haru/q26.html 
 
-# get testing html.
-wget -O ./y28_spring_q2.html -A html http://www.fe-siken.com/kakomon/28_haru/q2.html 
-nkf -S -w8 --in-place ./y28_spring_q2.html
+wget -O ./testdata/golden/fe/y28_spring_q11.html -A html http://www.fe-siken.com/kakomon/28_haru/q11.html
 
-wget -O ./y19_spring_q26.html -A html http://www.fe-siken.com/kakomon/19_haru/q26.html 
-nkf -S -w8 --in-place ./y19_spring_q26.html 
+wget -O ./testdata/golden/ap/y28_spring_q2.html -A html http://www.ap-siken.com/kakomon/28_haru/q2.html
 
-wget -O ./y28_spring_q11.html -A html http://www.fe-siken.com/kakomon/28_haru/q11.html 
-nkf -S -w8 --in-place ./y28_spring_q11.html 
+# create the golden files for the pages, review them before checking in.
+go test -run TestGolden -update .
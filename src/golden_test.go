@@ -0,0 +1,179 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// regenerates the golden files in testdata/golden.
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// TestGolden parses the pages at testdata/golden/[site]/[name].html, and
+// compares the responses with the golden files [name].json next to them.
+// The page named pm* is the afternoon question parsed by ParserMultipart,
+// otherwise ParserDefault is used.
+//
+// After the layout of the source site is changed, save the page as a fixture
+// and run the test with -update, then review the differences of the golden files.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "golden", "*", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixture in testdata/golden")
+	}
+	for _, file := range files {
+		site := filepath.Base(filepath.Dir(file))
+		name := strings.TrimSuffix(filepath.Base(file), ".html")
+		t.Run(site+"/"+name, func(t *testing.T) {
+			testGolden(t, site, name)
+		})
+	}
+}
+
+// it compares the response for the page at testdata/golden/[site]/[name].html
+// with the golden file, or updates the golden file by -update.
+func testGolden(t *testing.T, site, name string) {
+	file := filepath.Join("testdata", "golden", site, name+".html")
+	got, err := goldenResponse(file, site, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := strings.TrimSuffix(file, ".html") + ".json"
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v, run the test with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response differs from %s (-want +got):\n%s", golden, lineDiff(string(want), string(got)))
+	}
+}
+
+// it runs the golden test for the page saved from the real site by go generate,
+// named after the question such as y28_spring_q2. The page is kept as served,
+// in Shift_JIS, so that the layout changes of the site are caught.
+func testRealPage(t *testing.T, site, name string) {
+	if !realPageSaved(site, name) {
+		t.Skipf("real page %s/%s is not checked in, run go generate and the test with -update to save it", site, name)
+	}
+	testGolden(t, site, name)
+}
+
+func realPageSaved(site, name string) bool {
+	_, err := os.Stat(filepath.Join("testdata", "golden", site, name+".html"))
+	return err == nil
+}
+
+func TestParseDoc(t *testing.T) {
+	testRealPage(t, "fe", "y28_spring_q2")
+}
+
+func TestParseDocExplainCh(t *testing.T) {
+	for _, name := range []string{"y28_spring_q2", "y19_spring_q26"} {
+		t.Run(name, func(t *testing.T) {
+			testRealPage(t, "fe", name)
+		})
+	}
+}
+
+func TestParseDocHasImage(t *testing.T) {
+	for _, testcase := range []struct {
+		file string
+		want bool
+	}{
+		{filepath.Join("testdata", "golden", "fe", "image.html"), true},
+		{filepath.Join("testdata", "golden", "fe", "text.html"), false},
+		{filepath.Join("testdata", "golden", "fe", "table.html"), false},
+		{filepath.Join("testdata", "golden", "fe", "y19_spring_q26.html"), false},
+	} {
+		if name := strings.TrimSuffix(filepath.Base(testcase.file), ".html"); strings.HasPrefix(name, "y") && !realPageSaved("fe", name) {
+			continue
+		}
+		doc, err := goqueryDocFile(testcase.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := parseDoc(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.HasImage != testcase.want {
+			t.Errorf("%s: invalid HasImage, got: %v, want: %v", testcase.file, res.HasImage, testcase.want)
+		}
+	}
+}
+
+// it returns the response for the fixture encoded as the golden file.
+func goldenResponse(file, site, name string) ([]byte, error) {
+	body, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	id := ParserDefault
+	if strings.HasPrefix(name, "pm") {
+		id = ParserMultipart
+	}
+	parser, _ := LookupParser(id)
+	url := fmt.Sprintf("http://www.%s-siken.com/kakomon/28_haru/%s.html", site, name)
+	res, err := parsePage(Page{URL: url, Body: body, Type: PageHTML}, parser, "")
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// it returns the differences of the lines between a and b.
+// the removed lines are prefixed by "-", and the added lines by "+".
+func lineDiff(a, b string) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] is the length of the longest common lines of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&diff, "-%s\n", x[i])
+			i++
+		default:
+			fmt.Fprintf(&diff, "+%s\n", y[j])
+			j++
+		}
+	}
+	return diff.String()
+}
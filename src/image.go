@@ -0,0 +1,146 @@
+package src
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Image is the image data in the question.
+type Image struct {
+	Data        []byte
+	ContentType string
+}
+
+// DataURI returns the image as the data URI scheme,
+// which can be embedded into the html or json.
+func (img Image) DataURI() string {
+	return "data:" + img.ContentType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// Images returns the image blocks in the structured contents of the Response.
+// The returned blocks can be modified to rewrite the image locations.
+func (res *Response) Images() []*Block {
+	var images []*Block
+	collect := func(blocks []Block) {
+		for i := range blocks {
+			if blocks[i].Type == BlockImage {
+				images = append(images, &blocks[i])
+			}
+		}
+	}
+	collect(res.Body)
+	for i := range res.Choices {
+		collect(res.Choices[i].Content)
+		collect(res.Choices[i].Explanation)
+	}
+	collect(res.ExplanationBody)
+	return images
+}
+
+// ErrNotImage is the cause of ErrNotFound returned by GetImage
+// for the location which is not an image.
+var ErrNotImage = errors.New("not an image")
+
+// the extensions of the image locations accepted by GetImage.
+var imageExts = map[string]bool{
+	".png": true, ".gif": true, ".jpg": true, ".jpeg": true, ".bmp": true, ".webp": true,
+}
+
+// GetImage returns the image at imageURL, which must be located
+// in the same host as the source server and have the extension of the image.
+// The content which is not an image is ErrNotFound caused by ErrNotImage,
+// and the image too large to hold in memory is caused by ErrTooLarge.
+//
+// The image which appeared in the responses returned by the Getter is
+// requested without the interval wait time for the questions, as the web browser
+// does with the page. The other images wait for the interval as the questions do.
+// It is cached if the Getter has the image Cache given by WithImageCache.
+func (g *Getter) GetImage(ctx context.Context, imageURL string) (Image, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return Image{}, err
+	}
+	if src := hostOf(g.url.source().URL); u.Host != src {
+		return Image{}, fmt.Errorf("GetImage: image must be located in %s, but %s", src, u.Host)
+	}
+	if !imageExts[strings.ToLower(path.Ext(u.Path))] {
+		return Image{}, &FetchError{Kind: ErrNotFound, URL: imageURL, Err: ErrNotImage}
+	}
+
+	if g.imageCache != nil {
+		if data, ok := g.imageCache.Get(imageURL); ok {
+			return Image{Data: data, ContentType: http.DetectContentType(data)}, nil
+		}
+	}
+
+	var page Page
+	if g.images.contains(imageURL) {
+		page, err = g.upstream.getResource(ctx, imageURL)
+	} else {
+		page, err = g.upstream.get(ctx, imageURL)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	data := page.Body
+	// the content type is detected from the content rather than
+	// the served header, so that only the image is served as the image.
+	img := Image{Data: data, ContentType: http.DetectContentType(data)}
+	if !strings.HasPrefix(img.ContentType, "image/") {
+		return Image{}, &FetchError{Kind: ErrNotFound, URL: imageURL, Err: ErrNotImage}
+	}
+	if g.imageCache != nil {
+		g.imageCache.Put(imageURL, data)
+	}
+	return img, nil
+}
+
+// imageSet is the set of the image locations appeared in the responses.
+// The oldest location is removed when the number of the locations
+// exceeds maxImages. It is safe for concurrent use.
+type imageSet struct {
+	mu    sync.Mutex
+	set   map[string]bool
+	order []string
+}
+
+// the maximum number of the image locations in imageSet.
+const maxImages = 10000
+
+func newImageSet() *imageSet {
+	return &imageSet{set: make(map[string]bool)}
+}
+
+// add the image locations in the response.
+func (s *imageSet) add(res *Response) {
+	images := res.Images()
+	if len(images) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range images {
+		if s.set[b.Src] {
+			continue
+		}
+		s.set[b.Src] = true
+		s.order = append(s.order, b.Src)
+	}
+	for len(s.order) > maxImages {
+		delete(s.set, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *imageSet) contains(imageURL string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set[imageURL]
+}
@@ -41,7 +41,7 @@ func Get(year int, season string, no int, timeout uint64) *src.Response {
 		Year:   year,
 		Season: season,
 		No:     no,
-	})
+	}.Normalize())
 	if err != nil {
 		log.Println(err)
 		return nil
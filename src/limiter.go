@@ -0,0 +1,166 @@
+package src
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the frequency of the requests by the token bucket.
+// A token is refilled every interval up to burst tokens, and
+// each request consumes a token. It is safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	tokens   float64
+	last     time.Time // last time the tokens are updated.
+	latest   time.Time // time of the latest reservation to act.
+
+	// random variation added to the waiting time.
+	// it only extends the interval, never shortens it.
+	variation time.Duration
+}
+
+// NewRateLimiter returns new RateLimiter which allows a request per interval
+// with burst requests at once. it will panic if burst is not positive.
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	if burst <= 0 {
+		panic("src.NewRateLimiter: burst must be > 0")
+	}
+	return &RateLimiter{
+		interval: interval,
+		burst:    burst,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a request is allowed or ctx is done.
+// It returns ctx.Err() if ctx is done before the request is allowed.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	r := l.reserve()
+	wait := time.Until(r.act)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel(r)
+		return ctx.Err()
+	}
+}
+
+// reservation is the token consumed by a request.
+type reservation struct {
+	act    time.Time // time to act.
+	tokens float64   // consumed tokens including the variation.
+	prev   time.Time // latest of the limiter before the reservation.
+}
+
+// consume a token and return the reservation with the time the token is available.
+func (l *RateLimiter) reserve() reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.advance(now)
+	r := reservation{act: now, tokens: 1, prev: l.latest}
+	l.tokens -= 1
+	if l.tokens < 0 && l.interval > 0 {
+		// the variation is consumed as a part of the token, so that
+		// the next request is also delayed after this one.
+		if v := l.variation; v > 0 {
+			randMutex.Lock()
+			vt := float64(random.Int63n(int64(v+1))) / float64(l.interval)
+			randMutex.Unlock()
+			l.tokens -= vt
+			r.tokens += vt
+		}
+		if wait := time.Duration(-l.tokens * float64(l.interval)); wait > 0 {
+			r.act = now.Add(wait)
+		}
+	}
+	if r.act.After(l.latest) {
+		l.latest = r.act
+	}
+	return r
+}
+
+// give back the token consumed by the canceled reservation.
+// The token is not given back if the later reservation exists,
+// which is scheduled after the token, so that the requests
+// of the later reservation and the next one are not at once.
+func (l *RateLimiter) cancel(r reservation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !r.act.Equal(l.latest) {
+		return
+	}
+	l.advance(time.Now())
+	l.tokens += r.tokens
+	l.latest = r.prev
+}
+
+// refill tokens elapsed from the last update.
+// it must be called under the lock.
+func (l *RateLimiter) advance(now time.Time) {
+	if l.interval > 0 {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	} else {
+		l.tokens = float64(l.burst)
+	}
+	if max := float64(l.burst); l.tokens > max {
+		l.tokens = max
+	}
+	l.last = now
+}
+
+// make the interval at least d.
+func (l *RateLimiter) atLeast(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if d > l.interval {
+		l.advance(time.Now())
+		l.interval = d
+	}
+}
+
+// the limiters shared by the Getters accessing the same host.
+var hostLimiters = struct {
+	sync.Mutex
+	m map[string]*RateLimiter
+}{m: make(map[string]*RateLimiter)}
+
+// hostLimiter returns the RateLimiter for the host.
+// The limiter is shared by all of the Getters accessing the host,
+// and its interval is the longest one requested.
+func hostLimiter(host string, interval time.Duration) *RateLimiter {
+	hostLimiters.Lock()
+	defer hostLimiters.Unlock()
+
+	l, ok := hostLimiters.m[host]
+	if !ok {
+		l = NewRateLimiter(interval, 1)
+		l.variation = VariationCoef * time.Second
+		hostLimiters.m[host] = l
+		return l
+	}
+	l.atLeast(interval)
+	return l
+}
+
+// returns the host of the rawurl. empty if rawurl is invalid.
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,180 @@
+package src
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// File extensions for the question in the local directory tree.
+const (
+	ExtHTML = ".html" // raw web page as served.
+	ExtJSON = ".json" // parsed Response.
+)
+
+// LocalPath returns the relative path, without file extension,
+// for the question in the local directory tree.
+// For example, the question at H28, spring, No.2 is located at "28_haru/q2",
+// and R1, autumn, No.2 at "r01_aki/q2".
+func LocalPath(q Query) string {
+	return filepath.Join(q.session(), fmt.Sprintf("q%d", q.No))
+}
+
+// LocalFetcher fetches the question from the local dataset,
+// which is a directory tree or a zip archive created by Mirror.
+// The parsed Response (.json) is preferred to the raw web page (.html).
+//
+// Use it with the Getter to serve the questions without network access:
+//
+//	g := src.NewGetter(src.FE, src.LeastIntervalTime, src.WithFetcher(src.NewLocalFetcher(src.FE, "./mirror")))
+//
+// The Getter fetches through the copy of the LocalFetcher, which shares the dataset
+// and follows the catalogue of the sessions of the Getter, so that the sessions
+// found by UpdateSessions are also served. The LocalFetcher given is not changed.
+type LocalFetcher struct {
+	url  *urlGenerator
+	data *localDataset
+}
+
+// localDataset is the files of the local dataset shared by the copies of LocalFetcher.
+type localDataset struct {
+	path string
+
+	// open returns the content of the file at slash separated name
+	// relative to the dataset root.
+	open func(name string) (io.ReadCloser, error)
+
+	// for the zip archive.
+	zipOnce   sync.Once
+	zipErr    error
+	zipReader *zip.ReadCloser
+	zipFile   map[string]*zip.File
+}
+
+// NewLocalFetcher returns LocalFetcher for the dataset at path with the Source.
+// The path is treated as the zip archive if it has ".zip" extension,
+// otherwise the directory.
+func NewLocalFetcher(s Source, path string) *LocalFetcher {
+	d := &localDataset{path: path}
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		d.open = d.openZip
+	} else {
+		d.open = d.openDir
+	}
+	return &LocalFetcher{url: newURLGenerator(s), data: d}
+}
+
+// it returns the copy of the LocalFetcher with the URL generator,
+// which shares the dataset.
+func (f *LocalFetcher) withURL(url *urlGenerator) *LocalFetcher {
+	return &LocalFetcher{url: url, data: f.data}
+}
+
+// Fetch returns the page from the local dataset.
+// The parsed Response of the other JSONVersion is ignored as the cache does,
+// and the raw web page is used instead if exists.
+// The error is FetchError of ErrNotFound if the question is not in the dataset.
+func (f *LocalFetcher) Fetch(ctx context.Context, q Query) (Page, error) {
+	url, err := f.url.Generate(q)
+	if err != nil {
+		return Page{}, err
+	}
+	name := filepath.ToSlash(LocalPath(q))
+	var stale error
+	for _, t := range []struct {
+		ext, typ string
+	}{
+		{ExtJSON, PageJSON}, {ExtHTML, PageHTML},
+	} {
+		body, err := f.data.read(name + t.ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return Page{}, err
+		}
+		if t.typ == PageJSON {
+			if v := jsonVersion(body); v != JSONVersion {
+				stale = &ParseError{URL: url, Err: fmt.Errorf("version %q of %s%s, want %q", v, name, t.ext, JSONVersion)}
+				continue
+			}
+		}
+		return Page{URL: url, Body: body, Type: t.typ}, nil
+	}
+	if stale != nil {
+		return Page{}, stale
+	}
+	return Page{}, &FetchError{Kind: ErrNotFound, URL: url, Err: fmt.Errorf("%s is not in %s", name, f.data.path)}
+}
+
+// it returns the version of the Response encoded by json.
+// empty string is returned if the data is broken.
+func jsonVersion(data []byte) string {
+	var v struct {
+		Version string `json:"version"`
+	}
+	json.Unmarshal(data, &v)
+	return v.Version
+}
+
+func (d *localDataset) read(name string) ([]byte, error) {
+	r, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (d *localDataset) openDir(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.path, filepath.FromSlash(name)))
+}
+
+func (d *localDataset) openZip(name string) (io.ReadCloser, error) {
+	d.zipOnce.Do(d.loadZip)
+	if d.zipErr != nil {
+		return nil, d.zipErr
+	}
+	file, ok := d.zipFile[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return file.Open()
+}
+
+// open the zip archive and index its files.
+// The files can be placed under the top-level directory
+// in the archive such as "mirror/28_haru/q2.json".
+func (d *localDataset) loadZip() {
+	d.zipReader, d.zipErr = zip.OpenReader(d.path)
+	if d.zipErr != nil {
+		return
+	}
+	d.zipFile = make(map[string]*zip.File, len(d.zipReader.File))
+	for _, file := range d.zipReader.File {
+		name := path.Clean(file.Name)
+		d.zipFile[name] = file
+		if i := strings.Index(name, "/"); i >= 0 {
+			if trimmed := name[i+1:]; d.zipFile[trimmed] == nil {
+				d.zipFile[trimmed] = file
+			}
+		}
+	}
+}
+
+// Close releases the zip archive if opened.
+// The copies of the LocalFetcher used by the Getters are also closed.
+func (f *LocalFetcher) Close() error {
+	if f.data.zipReader != nil {
+		return f.data.zipReader.Close()
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// MirrorFailuresFile is the file name which records
+// the questions failed to mirror, in the mirror directory.
+const MirrorFailuresFile = "failures.json"
+
+// Mirror downloads the questions from the source server and
+// stores them into the local directory tree.
+// The stored directory can be used as the question source
+// instead of the source server.
+//
+// Mirror is resumable. The questions already stored are skipped,
+// and the questions failed previously or stored in the other JSONVersion
+// are tried again.
+type Mirror struct {
+	Getter *Getter
+	Dir    string // output directory.
+
+	// Progress is called after each question is processed, with non-nil error
+	// if the question is failed to mirror. It is optional.
+	Progress func(q Query, err error)
+}
+
+// MirrorReport is the summary of Mirror.Run.
+type MirrorReport struct {
+	Saved   int // number of the questions stored at this run.
+	Skipped int // number of the questions already stored.
+	Failed  int // number of the questions failed to store.
+}
+
+// Run mirrors all of the questions in QueryRange.
+// use maximum query range if MaxQueryRange is given.
+// The download interval is inserted by the Getter's Fetcher.
+//
+// It returns the error when the output directory can not be written or
+// ctx is canceled. The failures for each question are recorded in
+// MirrorFailuresFile and do not stop the process.
+func (m *Mirror) Run(ctx context.Context, qr QueryRange) (MirrorReport, error) {
+	var report MirrorReport
+
+	if qr == MaxQueryRange {
+		qr = m.Getter.url.MaxQueryRange()
+	}
+	if err := m.Getter.url.source().ValidatesRange(qr); err != nil {
+		return report, err
+	}
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return report, err
+	}
+	failures, err := m.loadFailures()
+	if err != nil {
+		return report, err
+	}
+
+	for _, q := range m.Getter.url.source().queries(qr) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		path := LocalPath(q)
+		if data, err := ioutil.ReadFile(filepath.Join(m.Dir, path+ExtJSON)); err == nil && jsonVersion(data) == JSONVersion {
+			report.Skipped++
+			continue
+		}
+
+		err := m.mirror(ctx, q)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			// canceled in the middle of the question. not a failure of the question.
+			return report, ctxErr
+		}
+		if err != nil {
+			report.Failed++
+			failures[path] = err.Error()
+		} else {
+			report.Saved++
+			delete(failures, path)
+		}
+		if err := m.saveFailures(failures); err != nil {
+			return report, err
+		}
+		if m.Progress != nil {
+			m.Progress(q, err)
+		}
+	}
+	return report, nil
+}
+
+// errEmptyQuestion indicates the downloaded page has no question.
+var errEmptyQuestion = errors.New("Mirror: empty question, the page may not exist")
+
+func (m *Mirror) mirror(ctx context.Context, q Query) error {
+	page, err := m.Getter.fetcher.Fetch(ctx, q)
+	if err != nil {
+		return err
+	}
+	res, err := m.Getter.parse(page)
+	if err != nil {
+		return err
+	}
+	if res.Question == "" {
+		return errEmptyQuestion
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.Dir, LocalPath(q))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if page.Type == PageHTML {
+		if err := WriteFileAtomic(path+ExtHTML, page.Body); err != nil {
+			return err
+		}
+	}
+	// JSON is written at last, since it marks the question as completed.
+	return WriteFileAtomic(path+ExtJSON, data)
+}
+
+func (m *Mirror) loadFailures() (map[string]string, error) {
+	failures := make(map[string]string)
+	data, err := ioutil.ReadFile(filepath.Join(m.Dir, MirrorFailuresFile))
+	switch {
+	case os.IsNotExist(err):
+		return failures, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &failures); err != nil {
+		return nil, fmt.Errorf("Mirror: broken %s: %v", MirrorFailuresFile, err)
+	}
+	return failures, nil
+}
+
+func (m *Mirror) saveFailures(failures map[string]string) error {
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(m.Dir, MirrorFailuresFile), data)
+}
@@ -0,0 +1,191 @@
+package src
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option is a optional setting for the Getter.
+type Option func(*Getter)
+
+// WithCache sets Cache to the Getter. The Getter looks up
+// the cache before accessing the source server, and stores
+// the response into the cache.
+func WithCache(c Cache) Option {
+	return func(g *Getter) {
+		g.cache = c
+	}
+}
+
+// WithImageCache sets Cache for the images in the questions to the Getter.
+// It should be apart from the Cache given by WithCache, so that the large
+// images do not push the questions out and the statistics of the questions
+// are not mixed with the images. The images are not cached by default.
+func WithImageCache(c Cache) Option {
+	return func(g *Getter) {
+		g.imageCache = c
+	}
+}
+
+// WithFetcher sets Fetcher to the Getter. The Getter fetches
+// the questions through it instead of the source server.
+// The options for the HTTP client are ignored if it is given.
+func WithFetcher(f Fetcher) Option {
+	return func(g *Getter) {
+		g.fetcher = f
+	}
+}
+
+// WithParseRetry sets the number of the other questions tried by GetRandom and
+// GetSelected when the question can not be parsed, i.e. the error is ErrParse.
+// zero means no retry, which is the default.
+func WithParseRetry(n int) Option {
+	return func(g *Getter) {
+		g.parseRetries = n
+	}
+}
+
+// httpOptions is the settings for the HTTP client
+// accessing the source server.
+type httpOptions struct {
+	client    *http.Client
+	transport http.RoundTripper
+	proxy     *url.URL
+	timeout   time.Duration
+	header    http.Header
+	limiter   *RateLimiter
+
+	retries   int           // the number of retries for the temporary failure.
+	retryWait time.Duration // the first wait time before retrying, doubled every retry.
+
+	breakerThreshold int // the number of consecutive failures to open the breaker.
+	breakerCooldown  time.Duration
+}
+
+// the default settings for retrying and the circuit breaker.
+const (
+	DefaultRetries   = 2
+	DefaultRetryWait = 500 * time.Millisecond
+
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Minute
+)
+
+var defaultHTTPOptions = httpOptions{
+	retries:          DefaultRetries,
+	retryWait:        DefaultRetryWait,
+	breakerThreshold: DefaultBreakerThreshold,
+	breakerCooldown:  DefaultBreakerCooldown,
+}
+
+// returns new http.Client constructed by the options.
+func (o httpOptions) newClient() *http.Client {
+	c := &http.Client{}
+	if o.client != nil {
+		*c = *o.client
+	}
+	if o.transport != nil {
+		c.Transport = o.transport
+	}
+	if o.proxy != nil {
+		base := c.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		if t, ok := base.(*http.Transport); ok {
+			t = t.Clone()
+			t.Proxy = http.ProxyURL(o.proxy)
+			c.Transport = t
+		}
+	}
+	if o.timeout > 0 {
+		c.Timeout = o.timeout
+	}
+	return c
+}
+
+// WithHTTPClient sets the HTTP client for accessing the source server.
+// http.DefaultClient is used by default.
+func WithHTTPClient(c *http.Client) Option {
+	return func(g *Getter) {
+		g.http.client = c
+	}
+}
+
+// WithTransport sets the RoundTripper of the HTTP client.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(g *Getter) {
+		g.http.transport = rt
+	}
+}
+
+// WithProxy sets the proxy server of the HTTP client.
+// It is effective only when the transport is *http.Transport,
+// which is true by default.
+func WithProxy(proxy *url.URL) Option {
+	return func(g *Getter) {
+		g.http.proxy = proxy
+	}
+}
+
+// WithTimeout sets the time limit for a request to the source server.
+// The request is also canceled by the context passed to the Getter.
+func WithTimeout(d time.Duration) Option {
+	return func(g *Getter) {
+		g.http.timeout = d
+	}
+}
+
+// WithHeader adds the header to every request to the source server.
+func WithHeader(key, value string) Option {
+	return func(g *Getter) {
+		if g.http.header == nil {
+			g.http.header = make(http.Header)
+		}
+		g.http.header.Add(key, value)
+	}
+}
+
+// WithUserAgent sets the User-Agent header of the requests to the source server.
+func WithUserAgent(ua string) Option {
+	return func(g *Getter) {
+		if g.http.header == nil {
+			g.http.header = make(http.Header)
+		}
+		g.http.header.Set("User-Agent", ua)
+	}
+}
+
+// WithRateLimiter sets the RateLimiter for the requests to the source server.
+// By default, the Getters accessing the same host share a RateLimiter
+// with the longest interval time of them.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(g *Getter) {
+		g.http.limiter = l
+	}
+}
+
+// WithRetry sets the number of retries for the temporary failure of
+// the request to the source server, such as 5xx status or the network error.
+// The wait time before retrying starts from wait and is doubled every retry
+// with some jitter. It is not retried beyond the deadline of the context.
+// zero retries means no retry. DefaultRetries and DefaultRetryWait by default.
+func WithRetry(retries int, wait time.Duration) Option {
+	return func(g *Getter) {
+		g.http.retries = retries
+		g.http.retryWait = wait
+	}
+}
+
+// WithCircuitBreaker sets the circuit breaker for the source server.
+// After threshold consecutive failures, the requests fail fast with
+// ErrUnavailable for cooldown time, then a trial request is sent.
+// zero threshold disables the breaker.
+// DefaultBreakerThreshold and DefaultBreakerCooldown by default.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(g *Getter) {
+		g.http.breakerThreshold = threshold
+		g.http.breakerCooldown = cooldown
+	}
+}
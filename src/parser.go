@@ -0,0 +1,154 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Parser parses the page of the question into Response.
+// The Document has Url of the page to resolve the image locations.
+// The page in the unexpected layout should be reported by ParseError.
+type Parser interface {
+	Parse(doc *goquery.Document) (Response, error)
+}
+
+// ParserFunc is an adapter to use the function as Parser.
+type ParserFunc func(doc *goquery.Document) (Response, error)
+
+// Parse calls f(doc).
+func (f ParserFunc) Parse(doc *goquery.Document) (Response, error) {
+	return f(doc)
+}
+
+// The ids of the built-in parsers for Source.Parser.
+const (
+	// the question with the four selections in the layout of fe-siken.com,
+	// which is shared by the other *-siken.com sites including
+	// the morning pages, am1_ and am2_, of the advanced examinations.
+	ParserDefault = "default"
+	// the question divided into the parts without the selections
+	// for the whole, such as the afternoon questions.
+	ParserMultipart = "multipart"
+)
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = map[string]Parser{
+		ParserDefault:   ParserFunc(parseDoc),
+		ParserMultipart: ParserFunc(parseMultipart),
+	}
+)
+
+// RegisterParser makes the parser available by the id in Source.Parser.
+// it will panic if the id is empty or already registered, or the parser is nil.
+func RegisterParser(id string, p Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	if id == "" || p == nil {
+		panic("src.RegisterParser: id and parser must not be empty")
+	}
+	if _, dup := parsers[id]; dup {
+		panic("src.RegisterParser: parser " + id + " is already registered")
+	}
+	parsers[id] = p
+}
+
+// LookupParser returns the parser registered by the id.
+// empty id means ParserDefault.
+func LookupParser(id string) (Parser, bool) {
+	if id == "" {
+		id = ParserDefault
+	}
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	p, ok := parsers[id]
+	return p, ok
+}
+
+// Parsers returns the sorted ids of the registered parsers.
+func Parsers() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	ids := make([]string, 0, len(parsers))
+	for id := range parsers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// it returns the parser named by the source, or its Rules if given.
+func (src Source) parser() (Parser, error) {
+	if r := src.Rules; r != nil {
+		if src.Parser != "" {
+			return nil, fmt.Errorf("Source: either Parser or Rules must be given, but both")
+		}
+		if err := r.validates(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	p, ok := LookupParser(src.Parser)
+	if !ok {
+		return nil, fmt.Errorf("Source: unknown Parser %s, must be either %s", src.Parser, strings.Join(Parsers(), ", "))
+	}
+	return p, nil
+}
+
+// the selectors of the required parts in the multipart page.
+const (
+	selectorMultipartQuestion = "div.main.kako > h3.qno ~ *"
+	selectorAnswerBox         = "div.main.kako > div.answerBox"
+)
+
+// it returns the text of the elements, in which each element and
+// the line break by <br> are separated by the newline.
+func lines(s *goquery.Selection) string {
+	var ls []string
+	s.Each(func(_ int, e *goquery.Selection) {
+		e = e.Clone()
+		e.Find("br").ReplaceWithHtml("\n")
+		for _, l := range strings.Split(e.Text(), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				ls = append(ls, l)
+			}
+		}
+	})
+	return strings.Join(ls, "\n")
+}
+
+// parseMultipart parses the page of the question divided into the parts.
+// The question is the contents between the question number and the answer,
+// and the answer is the whole text of the answer box, e.g. "設問1 a:ア b:エ".
+// The texts are separated into the lines by the parts.
+// Selections and Choices are empty.
+func parseMultipart(doc *goquery.Document) (Response, error) {
+	q_doc := doc.Find("div.main.kako > h3.qno").First().NextUntil("div.answerBox, h3")
+	ans_doc := doc.Find(selectorAnswerBox).First()
+	ansbg_doc := ans_doc.NextAllFiltered("div.ansbg").First()
+
+	if strings.TrimSpace(q_doc.Text()) == "" && q_doc.Find("img").Length() == 0 {
+		return Response{}, &ParseError{Selector: selectorMultipartQuestion, Err: errors.New("question is not found")}
+	}
+	answer := lines(ans_doc)
+	if answer == "" {
+		return Response{}, &ParseError{Selector: selectorAnswerBox, Err: errors.New("answer is not found")}
+	}
+
+	return Response{
+		Question:    lines(q_doc),
+		Answer:      answer,
+		Explanation: lines(ansbg_doc),
+		HasImage:    q_doc.Find("img").Length() > 0 || ans_doc.Find("img").Length() > 0,
+		Version:     JSONVersion,
+
+		Body:            parseBlocks(q_doc, doc.Url),
+		ExplanationBody: parseBlocks(ansbg_doc, doc.Url),
+		Topic:           parseTopic(doc),
+	}, nil
+}
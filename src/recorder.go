@@ -0,0 +1,186 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+// Cassette is the HTTP interactions recorded by Recorder,
+// which is stored as a JSON file.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a pair of the request and its response.
+type Interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+
+	// the response body is stored as text if it is valid UTF-8,
+	// otherwise encoded by base64, e.g. the page in ShiftJIS.
+	Body       string `json:"body,omitempty"`
+	BodyBase64 []byte `json:"bodyBase64,omitempty"`
+}
+
+func (i Interaction) body() []byte {
+	if i.BodyBase64 != nil {
+		return i.BodyBase64
+	}
+	return []byte(i.Body)
+}
+
+// The modes of the Recorder.
+const (
+	// the recorded responses are returned without network access.
+	// the request not recorded in the cassette is error.
+	RecorderReplay = "replay"
+	// the requests are sent, and the responses are recorded.
+	// The cassette is written by Recorder.Save.
+	RecorderRecord = "record"
+)
+
+// Recorder is http.RoundTripper which records the HTTP interactions into
+// the cassette file, or replays them, so that the Getter can be tested
+// end-to-end without network access:
+//
+//	rec, err := src.NewRecorder("testdata/fe.json", src.RecorderReplay, nil)
+//	g := src.NewGetter(src.FE, src.LeastIntervalTime, src.WithTransport(rec))
+//
+// The requests are matched by the method and URL.
+// It is safe for concurrent use.
+type Recorder struct {
+	path      string
+	mode      string
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns the Recorder for the cassette file at path in mode.
+// The cassette must exist for RecorderReplay, and it is extended
+// if exists for RecorderRecord.
+// The requests are sent by transport in RecorderRecord, nil means
+// http.DefaultTransport.
+func NewRecorder(path, mode string, transport http.RoundTripper) (*Recorder, error) {
+	if mode != RecorderReplay && mode != RecorderRecord {
+		return nil, fmt.Errorf("Recorder: mode must be either %s or %s, but %s", RecorderReplay, RecorderRecord, mode)
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	r := &Recorder{path: path, mode: mode, transport: transport}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) && mode == RecorderRecord:
+		return r, nil
+	case err != nil:
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("Recorder: broken cassette %s: %v", path, err)
+	}
+	return r, nil
+}
+
+// RoundTrip returns the recorded response for RecorderReplay, or sends the
+// request and records its response for RecorderRecord.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.mode == RecorderReplay {
+		i, ok := r.find(req.Method, req.URL.String())
+		if !ok {
+			return nil, fmt.Errorf("Recorder: %s %s is not recorded in %s", req.Method, req.URL, r.path)
+		}
+		return i.response(req), nil
+	}
+
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	i := Interaction{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: res.StatusCode,
+		Header: res.Header,
+	}
+	if utf8.Valid(body) {
+		i.Body = string(body)
+	} else {
+		i.BodyBase64 = body
+	}
+	r.record(i)
+	return i.response(req), nil
+}
+
+func (r *Recorder) find(method, url string) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range r.cassette.Interactions {
+		if i.Method == method && i.URL == url {
+			return i, true
+		}
+	}
+	return Interaction{}, false
+}
+
+// the interaction for the same request is replaced.
+func (r *Recorder) record(i Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for j, old := range r.cassette.Interactions {
+		if old.Method == i.Method && old.URL == i.URL {
+			r.cassette.Interactions[j] = i
+			return
+		}
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, i)
+}
+
+func (i Interaction) response(req *http.Request) *http.Response {
+	body := i.body()
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Status, http.StatusText(i.Status)),
+		StatusCode:    i.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Save writes the cassette file for RecorderRecord.
+// It does nothing for RecorderReplay.
+func (r *Recorder) Save() error {
+	if r.mode != RecorderRecord {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return WriteFileAtomic(r.path, append(data, '\n'))
+}
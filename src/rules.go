@@ -0,0 +1,137 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// Rules is the declarative rules to extract the question from the page
+// by the CSS selectors. It is used as the Parser for Source.Rules,
+// so that a new site can be added by the configuration only.
+type Rules struct {
+	// the element of the question. required.
+	Question string
+	// the selection items. required. e.g. "ul.selectList > li".
+	Selections string
+	// the label in the selection item. e.g. "button".
+	// empty means the labels are ア, イ, ウ and エ in order.
+	SelectionLabel string
+	// the content in the selection item. empty means the item itself.
+	SelectionText string
+	// the element having the label of the answer. required.
+	Answer string
+	// the element of the explanation. optional.
+	Explanation string
+	// the images in the question, the selections and the answer, for HasImage.
+	// empty means "img".
+	Image string
+}
+
+// it checks the rules have the required selectors in correct syntax.
+func (r *Rules) validates() error {
+	for _, f := range []struct {
+		name, selector string
+		required       bool
+	}{
+		{"Question", r.Question, true},
+		{"Selections", r.Selections, true},
+		{"SelectionLabel", r.SelectionLabel, false},
+		{"SelectionText", r.SelectionText, false},
+		{"Answer", r.Answer, true},
+		{"Explanation", r.Explanation, false},
+		{"Image", r.Image, false},
+	} {
+		if f.selector == "" {
+			if f.required {
+				return fmt.Errorf("Source: Rules.%s is required", f.name)
+			}
+			continue
+		}
+		if _, err := cascadia.Compile(f.selector); err != nil {
+			return fmt.Errorf("Source: Rules.%s: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// it returns the elements matching the selector in s.
+// empty selector matches nothing.
+func find(s *goquery.Selection, selector string) *goquery.Selection {
+	if selector == "" {
+		return s.Slice(0, 0)
+	}
+	return s.Find(selector)
+}
+
+// Parse extracts the question from the page by the rules.
+// The question must have the text or image, two or more selections,
+// and the answer in the labels of the selections.
+func (r *Rules) Parse(doc *goquery.Document) (Response, error) {
+	q_doc := doc.Find(r.Question).First()
+	sel_doc := doc.Find(r.Selections)
+	ans_doc := doc.Find(r.Answer).First()
+	exp_doc := find(doc.Selection, r.Explanation).First()
+	image := r.Image
+	if image == "" {
+		image = "img"
+	}
+
+	if strings.TrimSpace(q_doc.Text()) == "" && q_doc.Find(image).Length() == 0 {
+		return Response{}, &ParseError{Selector: r.Question, Err: errors.New("question is not found")}
+	}
+	if n := sel_doc.Length(); n < 2 {
+		return Response{}, &ParseError{Selector: r.Selections, Err: fmt.Errorf("selections must be 2 or more, but %d", n)}
+	}
+
+	var (
+		selections []string
+		choices    []Choice
+		labels     = make(map[string]bool)
+	)
+	sel_doc.Each(func(i int, s *goquery.Selection) {
+		label := strings.TrimSpace(find(s, r.SelectionLabel).First().Text())
+		if label == "" {
+			label = defaultLabel(i)
+		}
+		content := s
+		if r.SelectionText != "" {
+			content = s.Find(r.SelectionText)
+		}
+		selections = append(selections, label+": "+strings.TrimSpace(content.Text()))
+		choices = append(choices, Choice{Label: label, Content: parseBlocks(content, doc.Url)})
+		labels[label] = true
+	})
+
+	answer := strings.TrimSpace(ans_doc.Text())
+	if !labels[answer] {
+		return Response{}, &ParseError{Selector: r.Answer, Err: fmt.Errorf("answer %q is not in the labels of the selections", answer)}
+	}
+
+	return Response{
+		Question:    q_doc.Text(),
+		Selections:  selections,
+		Answer:      answer,
+		Explanation: exp_doc.Text(),
+		HasImage:    q_doc.Find(image).Length() > 0 || sel_doc.Find(image).Length() > 0 || ans_doc.Find(image).Length() > 0,
+		Version:     JSONVersion,
+
+		Body:            parseBlocks(q_doc, doc.Url),
+		Choices:         choices,
+		ExplanationBody: parseBlocks(exp_doc, doc.Url),
+		Topic:           parseTopic(doc),
+	}, nil
+}
+
+// it returns the label of i-th selection, ア, イ, ウ and エ,
+// or the number from 1 for the more selections.
+func defaultLabel(i int) string {
+	if i < len(choiceLabels) {
+		return choiceLabels[i]
+	}
+	return strconv.Itoa(i + 1)
+}
@@ -0,0 +1,302 @@
+package src
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/width"
+)
+
+// Index is an inverted index for the full-text search over the questions.
+// The Japanese text is tokenized into the bigrams of the characters,
+// so that no dictionary is needed.
+// It is safe for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[Query]*indexDoc
+	postings map[string]map[Query]float64 // token -> query -> weighted term frequency
+}
+
+type indexDoc struct {
+	fields [len(fieldWeights)]string
+	tokens []string // tokens in postings, used for removing.
+	topic  []string // classification of the question, see Response.Topic.
+}
+
+// the weights of the fields of the Response in the score.
+// question, selections and explanation in this order.
+var fieldWeights = [...]float64{3, 2, 1}
+
+func docFields(res Response) [len(fieldWeights)]string {
+	return [...]string{res.Question, strings.Join(res.Selections, "\n"), res.Explanation}
+}
+
+// Hit is a result of the search.
+type Hit struct {
+	Query   Query
+	Score   float64
+	Snippet string // part of the text around the matched words.
+}
+
+// NewIndex returns an empty Index.
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[Query]*indexDoc),
+		postings: make(map[string]map[Query]float64),
+	}
+}
+
+// Len returns the number of the indexed questions.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Add indexes the response as the question for q.
+// the question already indexed is replaced.
+func (idx *Index) Add(q Query, res Response) {
+	doc := &indexDoc{fields: docFields(res), topic: res.Topic}
+	tf := make(map[string]float64)
+	for i, f := range doc.fields {
+		for _, t := range tokenize(f, true) {
+			tf[t] += fieldWeights[i]
+		}
+	}
+	for t := range tf {
+		doc.tokens = append(doc.tokens, t)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(q)
+	idx.docs[q] = doc
+	for t, n := range tf {
+		p, ok := idx.postings[t]
+		if !ok {
+			p = make(map[Query]float64)
+			idx.postings[t] = p
+		}
+		p[q] = n
+	}
+}
+
+// Topic returns the indexed questions classified into the topic at any level,
+// in no particular order. See Response.HasTopic.
+func (idx *Index) Topic(topic string) []Query {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var qs []Query
+	for q, doc := range idx.docs {
+		for _, t := range doc.topic {
+			if t == topic {
+				qs = append(qs, q)
+				break
+			}
+		}
+	}
+	return qs
+}
+
+// it must be called under the lock.
+func (idx *Index) remove(q Query) {
+	doc, ok := idx.docs[q]
+	if !ok {
+		return
+	}
+	for _, t := range doc.tokens {
+		delete(idx.postings[t], q)
+		if len(idx.postings[t]) == 0 {
+			delete(idx.postings, t)
+		}
+	}
+	delete(idx.docs, q)
+}
+
+// AddStored indexes the questions in the range stored by the Getter,
+// without network access. See Getter.GetStored.
+// It returns the number of the indexed questions.
+func (idx *Index) AddStored(ctx context.Context, g *Getter, qr QueryRange) (int, error) {
+	if qr == MaxQueryRange {
+		qr = g.url.MaxQueryRange()
+	}
+	n := 0
+	for _, q := range g.url.source().queries(qr) {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if res, ok := g.GetStored(ctx, q); ok {
+			idx.Add(q, res)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Search returns the questions containing all of the words in text,
+// ordered by the score. At most limit hits are returned,
+// and the total number of the matched questions is also returned.
+func (idx *Index) Search(text string, limit int) ([]Hit, int) {
+	tokens := tokenize(text, false)
+	if len(tokens) == 0 {
+		return nil, 0
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// the score is the sum of TF-IDF for the tokens.
+	// the question must contain all of the tokens.
+	scores := make(map[Query]float64)
+	for i, t := range tokens {
+		p := idx.postings[t]
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(p)+1))
+		next := make(map[Query]float64, len(p))
+		for q, tf := range p {
+			if s, ok := scores[q]; ok || i == 0 {
+				next[q] = s + tf*idf
+			}
+		}
+		scores = next
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for q, score := range scores {
+		hits = append(hits, Hit{Query: q, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if a, b := hits[i].Score, hits[j].Score; a != b {
+			return a > b
+		}
+		a, b := hits[i].Query, hits[j].Query
+		if ya, yb := a.WesternYear(), b.WesternYear(); ya != yb {
+			return ya > yb
+		}
+		if a.Season != b.Season {
+			return a.Season < b.Season
+		}
+		return a.No < b.No
+	})
+	total := len(hits)
+	if limit >= 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		hits[i].Snippet = idx.docs[hits[i].Query].snippet(text)
+	}
+	return hits, total
+}
+
+// the number of characters around the matched words in the snippet.
+const (
+	snippetBefore = 20
+	snippetAfter  = 40
+)
+
+// it returns the part of the fields around the longest word in text.
+// the beginning of the question is returned if the word is not found.
+func (doc *indexDoc) snippet(text string) string {
+	var word []rune
+	for _, w := range words(text) {
+		if len(w) > len(word) {
+			word = w
+		}
+	}
+	for _, f := range doc.fields {
+		runes := []rune(f)
+		if i := indexRunes(normalize(runes), word); i >= 0 {
+			return excerpt(runes, i-snippetBefore, i+len(word)+snippetAfter)
+		}
+	}
+	runes := []rune(doc.fields[0])
+	return excerpt(runes, 0, snippetBefore+snippetAfter)
+}
+
+func excerpt(runes []rune, from, to int) string {
+	prefix, suffix := "…", "…"
+	if from <= 0 {
+		from, prefix = 0, ""
+	}
+	if to >= len(runes) {
+		to, suffix = len(runes), ""
+	}
+	text := strings.Join(strings.Fields(string(runes[from:to])), " ")
+	return prefix + text + suffix
+}
+
+func indexRunes(s, sub []rune) int {
+	if len(sub) == 0 {
+		return -1
+	}
+	for i := 0; i+len(sub) <= len(s); i++ {
+		match := true
+		for j, r := range sub {
+			if s[i+j] != r {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// it returns the text split into the tokens.
+// each word is tokenized into the bigrams, and the single character word
+// is tokenized into the unigram. the unigrams are also contained for the
+// indexing, so that the single character word can be searched.
+func tokenize(text string, unigram bool) []string {
+	var tokens []string
+	for _, w := range words(text) {
+		if unigram || len(w) == 1 {
+			for _, r := range w {
+				tokens = append(tokens, string(r))
+			}
+		}
+		for i := 0; i+1 < len(w); i++ {
+			tokens = append(tokens, string(w[i:i+2]))
+		}
+	}
+	return tokens
+}
+
+// it returns the normalized words in text, separated by the
+// characters other than the letters and the numbers.
+func words(text string) [][]rune {
+	var ws [][]rune
+	var w []rune
+	for _, r := range normalize([]rune(text)) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			w = append(w, r)
+			continue
+		}
+		if len(w) > 0 {
+			ws = append(ws, w)
+			w = nil
+		}
+	}
+	if len(w) > 0 {
+		ws = append(ws, w)
+	}
+	return ws
+}
+
+// it returns the runes folded into the narrow alphanumerics and
+// the wide katakana, and into the lower case.
+// each rune is mapped to a rune, so that the positions are preserved.
+func normalize(runes []rune) []rune {
+	norm := make([]rune, len(runes))
+	for i, r := range runes {
+		if f := width.LookupRune(r).Folded(); f != 0 {
+			r = f
+		}
+		norm[i] = unicode.ToLower(r)
+	}
+	return norm
+}
@@ -0,0 +1,132 @@
+package src
+
+import (
+	"fmt"
+	"time"
+)
+
+// Session is an examination session available in the source.
+// The catalogue of the sessions, Source.Sessions, tells which sessions
+// and how many questions exist, insteadly of the year range.
+type Session struct {
+	Era    string // EraHeisei | EraReiwa. empty means EraHeisei.
+	Year   int    // year in the era, or the Western year if not less than FirstWesternYear.
+	Season string // SeasonSpring | SeasonAutumn | any name of the special session.
+
+	// the last question number in the session.
+	// zero means the MaxNo of the Source.
+	MaxNo int
+}
+
+// it returns the query for the question in the session.
+func (s Session) query(no int) Query {
+	return Query{Era: s.Era, Year: s.Year, Season: s.Season, No: no}.Normalize()
+}
+
+// it returns the session containing the query in the catalogue.
+func (src Source) session(q Query) (Session, bool) {
+	q = q.Normalize()
+	for _, s := range src.Sessions {
+		sq := s.query(q.No)
+		if sq.Era == q.Era && sq.Year == q.Year && sq.Season == q.Season {
+			return s, true
+		}
+	}
+	return Session{}, false
+}
+
+func (src Source) maxNo(s Session) int {
+	if s.MaxNo == 0 {
+		return src.MaxNo
+	}
+	return s.MaxNo
+}
+
+// it returns the sessions in the range with the last question number.
+// The sessions in the catalogue are returned if the source has,
+// otherwise every year in the range is assumed to have the regular
+// sessions published at now.
+func (src Source) sessions(qr QueryRange, now time.Time) []Session {
+	var ss []Session
+	if len(src.Sessions) == 0 {
+		for _, q := range qr.sessions(now) {
+			ss = append(ss, Session{Era: q.Era, Year: q.Year, Season: q.Season, MaxNo: src.MaxNo})
+		}
+		return ss
+	}
+	for _, s := range src.Sessions {
+		if q := s.query(qr.MinNo); qr.Contains(q) {
+			s.MaxNo = src.maxNo(s)
+			ss = append(ss, s)
+		}
+	}
+	return ss
+}
+
+// it returns all of the queries in the range.
+func (src Source) queries(qr QueryRange) []Query {
+	nos := src.numbers(qr)
+	var qs []Query
+	for _, s := range src.sessions(qr, time.Now()) {
+		for _, no := range nos {
+			if no <= s.MaxNo {
+				qs = append(qs, s.query(no))
+			}
+		}
+	}
+	return qs
+}
+
+// generates random query in the range.
+// The session is selected uniformly, and then the question in it.
+func (src Source) randomQuery(qr QueryRange, now time.Time) (Query, error) {
+	nos := src.numbers(qr)
+	type candidate struct {
+		s Session
+		n int // the number of the questions in nos.
+	}
+	var cs []candidate
+	for _, s := range src.sessions(qr, now) {
+		n := 0
+		for n < len(nos) && nos[n] <= s.MaxNo {
+			n++
+		}
+		if n > 0 {
+			cs = append(cs, candidate{s, n})
+		}
+	}
+	if len(cs) == 0 {
+		return Query{}, fmt.Errorf("QueryRange: no session is available in the range")
+	}
+
+	randMutex.Lock()
+	c := cs[random.Intn(len(cs))]
+	no := nos[random.Intn(c.n)]
+	randMutex.Unlock()
+	return c.s.query(no), nil
+}
+
+// check whether the catalogue has correct values.
+func (src Source) validatesSessions() error {
+	seen := make(map[Query]bool, len(src.Sessions))
+	for i, s := range src.Sessions {
+		q := s.query(src.MinNo)
+		if err := q.validatesEra(); err != nil {
+			return fmt.Errorf("Source: Sessions[%d]: %v", i, err)
+		}
+		if s.Season == "" {
+			return fmt.Errorf("Source: Sessions[%d] must have Season", i)
+		}
+		if y, min, max := q.WesternYear(), westernYear(src.MinYear), src.maxYear(); y < min || y > max {
+			return fmt.Errorf("Source: Sessions[%d] year must be in [%d:%d], but %d", i, min, max, y)
+		}
+		if n := src.maxNo(s); n < src.MinNo || n > src.MaxNo {
+			return fmt.Errorf("Source: Sessions[%d] MaxNo must be in [%d:%d], but %d", i, src.MinNo, src.MaxNo, n)
+		}
+		if seen[q] {
+			return fmt.Errorf("Source: Sessions[%d] %s is duplicated", i, q.session())
+		}
+		seen[q] = true
+	}
+	return nil
+}
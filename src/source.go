@@ -11,37 +11,78 @@ import "fmt"
 var (
 	// source loacation for F.E. examination.
 	FE = Source{
-		URL: `http://www.fe-siken.com/kakomon/{{.Year}}_{{.Season}}/q{{.No}}.html`,
+		URL: `http://www.fe-siken.com/kakomon/{{sikenYear .}}_{{.Season}}/q{{.No}}.html`,
 		QueryRange: QueryRange{
-			MaxYear: 29, MinYear: 13,
+			MaxYear: MaxYear, MinYear: MinYear,
 			MaxNo: 80, MinNo: 1,
 			Season: SeasonAll,
 		},
+		Categories: defaultCategories,
 	}
 
 	// source location for A.P. examination.
 	AP = Source{
-		URL: `http://www.ap-siken.com/kakomon/{{.Year}}_{{.Season}}/q{{.No}}.html`,
+		URL: `http://www.ap-siken.com/kakomon/{{sikenYear .}}_{{.Season}}/q{{.No}}.html`,
 		QueryRange: QueryRange{
-			MaxYear: 29, MinYear: 13,
+			MaxYear: MaxYear, MinYear: MinYear,
 			MaxNo: 80, MinNo: 1,
 			Season: SeasonAll,
 		},
+		Categories: defaultCategories,
 	}
 )
 
 // Source is the source definition for getting the questions
 // from the extenal server.
 type Source struct {
-	URL string // URL template for source server.
+	// URL template for source server, which accepts Query and the functions
+	// era, western, year2 and sikenYear. e.g. {{sikenYear .}}_{{.Season}} is
+	// rendered as 28_haru, r01_aki and 02_oct.
+	URL string
 
-	QueryRange // Acceptable range for query.
+	// Acceptable range for query.
+	// zero MaxYear means the current year. It is meant to be used with
+	// Sessions or IndexURL, otherwise the sessions which were not held
+	// as usual in the later years are also selected.
+	QueryRange
+
+	// maps the question numbers to the categories. optional.
+	Categories []Category
+
+	// URL of the index page listing the sessions, such as
+	// http://www.fe-siken.com/kakomon/. optional.
+	// The catalogue, Sessions, is discovered from it by Getter.UpdateSessions.
+	IndexURL string
+
+	// id of the parser for the pages, registered by RegisterParser.
+	// empty means ParserDefault.
+	Parser string
+	// declarative rules to extract the question from the pages,
+	// used insteadly of Parser. optional.
+	Rules *Rules
+	// character encoding of the pages, such as shift_jis, euc-jp and utf-8.
+	// empty means it is detected from Content-Type, the meta charset in
+	// the pages, or the contents.
+	Encoding string
+
+	// catalogue of the available sessions. optional.
+	// if it is empty, every year in the QueryRange is assumed to have
+	// the regular sessions with MinNo to MaxNo questions.
+	Sessions []Session
 }
 
 // check whether itself has correct values?
 // return nil if it is valid.
 func (src Source) ValidatesSelf() error {
-	if src.MaxYear < src.MinYear {
+	for _, y := range []int{src.MaxYear, src.MinYear} {
+		if y == 0 && y == src.MaxYear {
+			y = src.maxYear() // the current year.
+		}
+		if err := validatesYear(y); err != nil {
+			return fmt.Errorf("Source: %v", err)
+		}
+	}
+	if src.maxYear() < westernYear(src.MinYear) {
 		return fmt.Errorf("Source: MaxYear must be larger then MinYear but Max: %d, Min: %d", src.MaxYear, src.MinYear)
 	}
 	if src.MaxNo < src.MinNo {
@@ -49,30 +90,58 @@ func (src Source) ValidatesSelf() error {
 	}
 	switch src.Season {
 	case SeasonSpring, SeasonAutumn, SeasonAll:
-		return nil
 	default:
 		return fmt.Errorf("Source: Season must be either %s, %s or %s", SeasonSpring, SeasonAutumn, SeasonAll)
 	}
+	if err := validatesCategories(src.Categories); err != nil {
+		return err
+	}
+	if err := src.validatesSessions(); err != nil {
+		return err
+	}
+	if _, err := src.parser(); err != nil {
+		return err
+	}
+	if src.Encoding != "" {
+		if _, err := lookupEncoding(src.Encoding); err != nil {
+			return err
+		}
+	}
+	if c := src.Category; c != "" && !src.HasCategory(c) {
+		return fmt.Errorf("Source: Category %s is not in Categories", c)
+	}
+	return nil
+}
+
+// it returns the source whose zero MaxYear is replaced with the current year,
+// so that its QueryRange can be used as the actual range.
+func (src Source) resolved() Source {
+	src.MaxYear = src.maxYear()
+	return src
 }
 
 // check whether given query has correct value range
 // in the source? nil error means query is valid.
 func (src Source) Validates(q Query) error {
-	if y := q.Year; y < src.MinYear || y > src.MaxYear {
-		return fmt.Errorf("Query: year must be in [%d:%d], but %d", src.MinYear, src.MaxYear, y)
+	if len(src.Sessions) > 0 {
+		return src.validatesInCatalogue(q)
+	}
+	if err := q.validatesSession(); err != nil {
+		return err
+	}
+	if y, min, max := q.WesternYear(), westernYear(src.MinYear), src.maxYear(); y < min || y > max {
+		return fmt.Errorf("Query: year must be in [%d:%d], but %d", min, max, y)
 	}
 	if n := q.No; n < src.MinNo || n > src.MaxNo {
 		return fmt.Errorf("Query: Question No. must be in [%d:%d], but %d", src.MinNo, src.MaxNo, n)
 	}
 
-	qSeason := q.Season
+	qSeason := regularSeason(q.Season)
 	switch {
-	case qSeason != SeasonSpring && qSeason != SeasonAutumn:
-		return fmt.Errorf("Query: Season must be either %s or %s, but %s", SeasonSpring, SeasonAutumn, qSeason)
 	case src.Season == SeasonSpring && qSeason != SeasonSpring:
-		return fmt.Errorf("Query: Season must be %s but %s", SeasonSpring, qSeason)
+		return fmt.Errorf("Query: Season must be %s but %s", SeasonSpring, q.Season)
 	case src.Season == SeasonAutumn && qSeason != SeasonAutumn:
-		return fmt.Errorf("Query: Season must be %s but %s", SeasonAutumn, qSeason)
+		return fmt.Errorf("Query: Season must be %s but %s", SeasonAutumn, q.Season)
 	}
 	return nil
 }
@@ -80,22 +149,26 @@ func (src Source) Validates(q Query) error {
 // check whether QueryRange is in correct range in the Source?
 // return nil if query is valid.
 func (src Source) ValidatesRange(qr QueryRange) error {
-	{
-		// qr.Season is validated later. dummy Season is used insteadly.
-		dummySeason := SeasonSpring
-		if src.Season == SeasonAutumn {
-			dummySeason = SeasonAutumn
+	min, max := westernYear(src.MinYear), src.maxYear()
+	for _, y := range []int{qr.MaxYear, qr.MinYear} {
+		if y == 0 && y == qr.MaxYear {
+			y = qr.maxYear() // the current year.
 		}
-		if err := src.Validates(Query{qr.MaxYear, dummySeason, qr.MaxNo}); err != nil {
-			return err
+		if err := validatesYear(y); err != nil {
+			return fmt.Errorf("QueryRange: %v", err)
 		}
-		if err := src.Validates(Query{qr.MinYear, dummySeason, qr.MinNo}); err != nil {
-			return err
+		if w := westernYear(y); w < min || w > max {
+			return fmt.Errorf("Query: year must be in [%d:%d], but %d", min, max, w)
+		}
+	}
+	for _, n := range []int{qr.MaxNo, qr.MinNo} {
+		if n < src.MinNo || n > src.MaxNo {
+			return fmt.Errorf("Query: Question No. must be in [%d:%d], but %d", src.MinNo, src.MaxNo, n)
 		}
 	}
 
 	// check the relation for min and max.
-	if qr.MaxYear < qr.MinYear {
+	if qr.maxYear() < westernYear(qr.MinYear) {
 		return fmt.Errorf("QueryRange: MaxYear must be larger then MinYear but Max: %d, Min: %d", qr.MaxYear, qr.MinYear)
 	}
 	if qr.MaxNo < qr.MinNo {
@@ -112,5 +185,32 @@ func (src Source) ValidatesRange(qr QueryRange) error {
 	case src.Season == SeasonAutumn && qSeason != SeasonAutumn:
 		return fmt.Errorf("Query: Season must be %s but %s", SeasonAutumn, qSeason)
 	}
+	// check category
+	if c := qr.Category; c != "" {
+		if !src.HasCategory(c) {
+			return fmt.Errorf("QueryRange: unknown Category %s", c)
+		}
+		if src.Category != "" && c != src.Category {
+			return fmt.Errorf("QueryRange: Category must be %s but %s", src.Category, c)
+		}
+		if len(src.numbers(qr)) == 0 {
+			return fmt.Errorf("QueryRange: no question of Category %s in No. [%d:%d]", c, qr.MinNo, qr.MaxNo)
+		}
+	}
+	return nil
+}
+
+// check whether given query is in the catalogue of the sessions.
+func (src Source) validatesInCatalogue(q Query) error {
+	if err := q.validatesEra(); err != nil {
+		return err
+	}
+	s, ok := src.session(q)
+	if !ok {
+		return fmt.Errorf("Query: the session %s is not available", q.Normalize().session())
+	}
+	if n, max := q.No, src.maxNo(s); n < src.MinNo || n > max {
+		return fmt.Errorf("Query: Question No. must be in [%d:%d], but %d", src.MinNo, max, n)
+	}
 	return nil
 }
@@ -5,13 +5,17 @@ package src
 import (
 	"bytes"
 	"context"
-	"io/ioutil"
-	"net/http"
+	"encoding/json"
+	"errors"
+	"fmt"
+	neturl "net/url"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 )
 
@@ -31,88 +35,265 @@ type Response struct {
 	URL string `json:"url"` // source URL
 
 	Version string `json:"version"` // version for json data structure
+
+	// structured contents since version 2.0.0.
+	// the flat fields above are remained for compatibility.
+	Body            []Block  `json:"body"`            // question as the ordered blocks.
+	Choices         []Choice `json:"choices"`         // selections with their explanations.
+	ExplanationBody []Block  `json:"explanationBody"` // whole explanation as the ordered blocks.
+
+	// category of the question defined by the Source. empty if not defined.
+	// it is set when the response is served, and does not change the version.
+	Category string `json:"category"`
+	Field    string `json:"field"`
+
+	// classification of the question in the source page, from the major
+	// to the minor, e.g. ["テクノロジ系", "技術要素", "データベース"].
+	// since version 2.1.0.
+	Topic []string `json:"topic"`
 }
 
 // current version for json data structure.
-const JSONVersion = "1.0.0"
+const JSONVersion = "2.1.0"
 
 var defaultGetter = NewGetter(FE, LeastIntervalTime)
 
 // the minimum time for request interval.
 const LeastIntervalTime = 5 * time.Second
 
-// interval time varies plus or minus VariationCoef second.
+// interval time varies from 0 to plus VariationCoef second.
 const VariationCoef = 2
 
 // Getter is a interface for F.E. question and answer from webpage.
 // serial requests are splited by some interval time so that
 // the number of accessing the outer server is reduced.
+// The interval is shared by the Getters accessing the same host.
+//
+// Getter is safe for concurrent use.
 type Getter struct {
 	url *urlGenerator
 
-	intervalTime time.Duration
-	lastRequest  time.Time
+	fetcher    Fetcher
+	cache      Cache
+	imageCache Cache
+
+	http     httpOptions  // used to construct the default Fetcher.
+	upstream *httpFetcher // accesses the source server for the default Fetcher, the images and the index page.
+
+	parseRetries int // the number of the other questions tried by GetRandom and GetSelected.
+
+	images *imageSet // the images appeared in the responses, requested without the interval.
 }
 
 // return new Getter with question source and
 // intervalTime for server request.
 // it will panic if intervalTime less than LeastIntervalTime.
-func NewGetter(s Source, intervalTime time.Duration) *Getter {
+func NewGetter(s Source, intervalTime time.Duration, opts ...Option) *Getter {
 	if intervalTime < LeastIntervalTime {
 		panic("intervalTime must be >= " + LeastIntervalTime.String())
 	}
-	return &Getter{
-		url:          newURLGenerator(s),
-		intervalTime: intervalTime,
-		lastRequest:  time.Time{},
+	g := &Getter{
+		url:    newURLGenerator(s),
+		http:   defaultHTTPOptions,
+		images: newImageSet(),
 	}
-}
-
-// To reduce the frequent request for the server,
-// wait interval time between the requests.
-func (g *Getter) wait() {
-	// all of the exported method check this.
-	if g.intervalTime < LeastIntervalTime {
-		panic("intervalTime must be >= " + LeastIntervalTime.String())
+	for _, opt := range opts {
+		opt(g)
 	}
-
-	randMutex.Lock()
-	coef := random.Intn(2*VariationCoef+1) - VariationCoef
-	randMutex.Unlock()
-
-	noisedInterval := g.intervalTime + time.Duration(coef)*time.Second
-	if wait := noisedInterval - time.Since(g.lastRequest); wait > 0 {
-		time.Sleep(wait)
+	g.upstream = newHTTPFetcher(g.url, intervalTime, g.http)
+	if g.fetcher == nil {
+		g.fetcher = g.upstream
 	}
-	g.lastRequest = time.Now()
+	if f, ok := g.fetcher.(*LocalFetcher); ok {
+		// share the URL and the catalogue of the sessions,
+		// without changing the LocalFetcher of the caller.
+		g.fetcher = f.withURL(g.url)
+	}
+	return g
 }
 
 // Get returns a response, which contains F.E question and its answer selected by Query, from website.
 // This process takes some time. You can cancel it by canceling context.
 //
-// The interval wait time is inserted between serial calling of this method.
+// The interval wait time is inserted between the requests to the same host.
+// If the Getter has Cache, the cached response is returned without waiting.
 func (g *Getter) Get(ctx context.Context, q Query) (Response, error) {
-	url, err := g.url.Generate(q)
+	if err := g.url.source().Validates(q); err != nil {
+		return Response{}, err
+	}
+
+	key := cacheKey(g.url.source(), q)
+	if res, ok := g.cached(key); ok {
+		g.images.add(&res)
+		return g.categorize(res, q), nil
+	}
+	page, err := g.fetcher.Fetch(ctx, q)
 	if err != nil {
 		return Response{}, err
 	}
-	g.wait()
-	return getResponse(ctx, url)
+	res, err := g.parse(page)
+	if err != nil {
+		return Response{}, err
+	}
+	g.store(key, res)
+	g.images.add(&res)
+	return g.categorize(res, q), nil
+}
+
+// it parses the page by the parser of the source.
+// the error other than the unknown parser is ErrParse.
+func (g *Getter) parse(p Page) (Response, error) {
+	parser, err := g.url.source().parser()
+	if err != nil {
+		return Response{}, err
+	}
+	res, err := parsePage(p, parser, g.url.source().Encoding)
+	if err != nil && !errors.Is(err, ErrParse) {
+		err = &ParseError{URL: p.URL, Err: err}
+	}
+	return res, err
+}
+
+// GetStored returns a response stored locally, in the Cache or
+// the local dataset given by WithFetcher(LocalFetcher), without network access.
+// false is returned if the question is not stored.
+// The statistics of the Cache are not updated if it implements Peeker.
+func (g *Getter) GetStored(ctx context.Context, q Query) (Response, bool) {
+	if err := g.url.source().Validates(q); err != nil {
+		return Response{}, false
+	}
+	if g.local() {
+		res, err := g.Get(ctx, q)
+		return res, err == nil
+	}
+	if g.cache == nil {
+		return Response{}, false
+	}
+	res, ok := decodeCached(peek(g.cache, cacheKey(g.url.source(), q)))
+	if !ok {
+		return Response{}, false
+	}
+	g.images.add(&res)
+	return g.categorize(res, q), true
+}
+
+// it sets the category of the question to the response.
+// the category is not cached, so that the changes of the Source's
+// Categories are applied to the cached responses.
+func (g *Getter) categorize(res Response, q Query) Response {
+	if c, ok := g.url.source().CategoryOf(q.No); ok {
+		res.Category, res.Field = c.Name, c.Field
+	}
+	return res
 }
 
 // GetRandom returns a response, which contains F.E question and its answer selected randomly
 // in range QueryRange, from website.
 // This process takes some time. You can cancel it by canceling context.
 //
-// The interval wait time is inserted between serial calling of this method.
+// The interval wait time is inserted between the requests to the same host.
 // use maximum query range if MaxQueryRange is given.
+//
+// If the question can not be parsed, the other questions are tried
+// up to the times given by WithParseRetry.
 func (g *Getter) GetRandom(ctx context.Context, qr QueryRange) (Response, error) {
-	url, err := g.url.Random(qr)
-	if err != nil {
-		return Response{}, err
+	_, res, err := g.GetSelected(ctx, func(failed map[Query]error) (Query, error) {
+		var q Query
+		for draws := 0; draws < maxDraws; draws++ {
+			var err error
+			if q, err = g.url.RandomQuery(qr); err != nil {
+				return q, err
+			}
+			if _, ok := failed[q]; !ok {
+				break
+			}
+		}
+		return q, nil
+	})
+	return res, err
+}
+
+// the maximum number of drawing the random queries in GetRandom.
+const maxDraws = 100
+
+// GetSelected returns a response of the question selected by next, with its Query.
+// If the question can not be parsed, next is called again with the queries
+// failed so far and their errors, up to the times given by WithParseRetry.
+// The error is returned if next selects the query failed already, which means
+// no other question is available, or if next returns the error.
+func (g *Getter) GetSelected(ctx context.Context, next func(failed map[Query]error) (Query, error)) (Query, Response, error) {
+	failed := make(map[Query]error)
+	for {
+		q, err := next(failed)
+		if err != nil {
+			return q, Response{}, err
+		}
+		if err, ok := failed[q]; ok {
+			return q, Response{}, err
+		}
+		res, err := g.Get(ctx, q)
+		if err == nil || !errors.Is(err, ErrParse) || len(failed) >= g.parseRetries {
+			return q, res, err
+		}
+		failed[q] = err
+	}
+}
+
+// RandomQuery returns a Query selected randomly in range QueryRange.
+// use maximum query range if MaxQueryRange is given.
+func (g *Getter) RandomQuery(qr QueryRange) (Query, error) {
+	return g.url.RandomQuery(qr)
+}
+
+// UpstreamStatus returns the status of the circuit breaker
+// for the source server.
+func (g *Getter) UpstreamStatus() BreakerStatus {
+	return g.upstream.breaker.status()
+}
+
+// CacheStats returns the statistics of the Getter's Cache.
+// zero value is returned if the Getter has no Cache.
+func (g *Getter) CacheStats() CacheStats {
+	if g.cache == nil {
+		return CacheStats{}
+	}
+	return g.cache.Stats()
+}
+
+// it reports whether the questions are read from the local dataset.
+// The responses from it are not cached, which is read as fast as the cache.
+func (g *Getter) local() bool {
+	_, ok := g.fetcher.(*LocalFetcher)
+	return ok
+}
+
+func (g *Getter) cached(key string) (Response, bool) {
+	if g.cache == nil || g.local() {
+		return Response{}, false
+	}
+	return decodeCached(g.cache.Get(key))
+}
+
+// it returns the response decoded from the cached data if ok.
+func decodeCached(data []byte, ok bool) (Response, bool) {
+	if !ok {
+		return Response{}, false
+	}
+	var res Response
+	if err := json.Unmarshal(data, &res); err != nil || res.Version != JSONVersion {
+		// broken or old data structure. fetch again.
+		return Response{}, false
+	}
+	return res, true
+}
+
+func (g *Getter) store(key string, res Response) {
+	if g.cache == nil || g.local() {
+		return
+	}
+	if data, err := json.Marshal(res); err == nil {
+		g.cache.Put(key, data)
 	}
-	g.wait()
-	return getResponse(ctx, url)
 }
 
 // Get() returns a response, which contains F.E question and its answer selected by Query, from website.
@@ -128,54 +309,35 @@ func GetRandom(ctx context.Context, qr QueryRange) (Response, error) {
 	return defaultGetter.GetRandom(ctx, qr)
 }
 
-func getResponse(ctx context.Context, url string) (Response, error) {
-	resCh := make(chan Response, 1)
-	errCh := make(chan error, 1)
-
-	go func() {
-		defer close(resCh)
-		defer close(errCh)
-		doc, err := newDocument(url)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		res, err := parseDoc(doc)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		res.URL = url
-		resCh <- res
-	}()
-
-	select {
-	case <-ctx.Done():
-		return Response{}, ctx.Err()
-	case res := <-resCh:
-		return res, nil
-	case err := <-errCh:
+// parseHTML() returns Response parsed from the raw content of the url by parser.
+// The content is decoded from enc.
+func parseHTML(url string, html []byte, parser Parser, enc encoding.Encoding) (Response, error) {
+	doc, err := newDocument(html, enc)
+	if err != nil {
+		return Response{}, err
+	}
+	// the base URL to resolve the image locations.
+	if u, err := neturl.Parse(url); err == nil {
+		doc.Url = u
+	}
+	res, err := parser.Parse(doc)
+	if perr, ok := err.(*ParseError); ok {
+		perr.URL = url
+	}
+	if err != nil {
 		return Response{}, err
 	}
+	res.URL = url
+	return res, nil
 }
 
 // newDocument() returns goquery.Document with UTF8 form.
 //
-// Because target url has a content encoded by ShiftJIS,
-// conversion from ShiftJIS to UTF8 is required before parsing goquery.Document.
-// newDocument() performs that.
-func newDocument(url string) (*goquery.Document, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	html, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	r := transform.NewReader(bytes.NewReader(html), japanese.ShiftJIS.NewDecoder())
+// Because target url has a content encoded by ShiftJIS or the other encoding,
+// conversion from enc to UTF8 is required before parsing goquery.Document.
+// newDocument() performs that. See detectEncoding for enc.
+func newDocument(html []byte, enc encoding.Encoding) (*goquery.Document, error) {
+	r := transform.NewReader(bytes.NewReader(html), enc.NewDecoder())
 	return goquery.NewDocumentFromReader(r)
 }
 
@@ -213,24 +375,19 @@ func parseDoc(doc *goquery.Document) (Response, error) {
 		panic("nil Document")
 	}
 
+	// structured contents. these must be parsed before
+	// the explanation is modified below.
+	body := parseBlocks(q_doc, doc.Url)
+	choices := parseChoices(sel_doc, ansbg_doc, doc.Url)
+	explanationBody := parseBlocks(ansbg_doc, doc.Url)
+
 	// make it visible answer characters in the explanation.
-	const (
-		explainA = "ul > li.lia"
-		explainI = "ul > li.lii"
-		explainU = "ul > li.liu"
-		explainE = "ul > li.lie"
-	)
-	if firstLi := ansbg_doc.Find(explainA); firstLi.Length() > 0 {
+	if firstLi := ansbg_doc.Find(choiceExplanations[0]); firstLi.Length() > 0 {
 		ul := firstLi.Parent()
 		ul.PrependHtml("\n")
-		for _, selector := range []struct {
-			ch    string
-			query string
-		}{
-			{"ア", explainA}, {"イ", explainI}, {"ウ", explainU}, {"エ", explainE},
-		} {
-			sel := ansbg_doc.Find(selector.query)
-			sel.SetText(selector.ch + ":" + sel.Text() + "\n") // modifies content.
+		for i, query := range choiceExplanations {
+			sel := ansbg_doc.Find(query)
+			sel.SetText(choiceLabels[i] + ":" + sel.Text() + "\n") // modifies content.
 		}
 	}
 
@@ -240,6 +397,10 @@ func parseDoc(doc *goquery.Document) (Response, error) {
 		has_image = true
 	}
 
+	if err := validatesDoc(q_doc, sel_doc, ansch_doc); err != nil {
+		return Response{}, err
+	}
+
 	return Response{
 		Question:    q_doc.Text(),
 		Selections:  selections,
@@ -247,13 +408,135 @@ func parseDoc(doc *goquery.Document) (Response, error) {
 		Explanation: ansbg_doc.Text(),
 		HasImage:    has_image,
 		Version:     JSONVersion,
+
+		Body:            body,
+		Choices:         choices,
+		ExplanationBody: explanationBody,
+		Topic:           parseTopic(doc),
 	}, nil
 }
 
+// the selectors of the required parts in the page, named in ParseError.
+const (
+	selectorQuestion   = "div.main.kako > h3.qno + *"
+	selectorSelections = "div.main.kako > div.ansbg > ul.selectList.cf > li"
+	selectorAnswer     = "div.main.kako > div.answerBox span#answerChar"
+)
+
+// it checks the required parts are found in the page, so that the changed
+// layout of the page is not returned as the empty question.
+// The question must have the text or image, the four selections, and
+// the answer in ア to エ.
+func validatesDoc(q_doc, sel_doc, ansch_doc *goquery.Selection) error {
+	if q_doc.Length() == 0 {
+		return &ParseError{Selector: selectorQuestion, Err: errors.New("question is not found")}
+	}
+	if strings.TrimSpace(q_doc.Text()) == "" && q_doc.Find("img").Length() == 0 {
+		return &ParseError{Selector: selectorQuestion, Err: errors.New("question is empty")}
+	}
+	if n := sel_doc.Children().Filter("li").Length(); n != len(choiceLabels) {
+		return &ParseError{Selector: selectorSelections,
+			Err: fmt.Errorf("selections must be %d, but %d", len(choiceLabels), n)}
+	}
+	if ansch_doc.Length() == 0 {
+		return &ParseError{Selector: selectorAnswer, Err: errors.New("answer is not found")}
+	}
+	ans := strings.TrimSpace(ansch_doc.Text())
+	for _, l := range choiceLabels {
+		if ans == l {
+			return nil
+		}
+	}
+	return &ParseError{Selector: selectorAnswer,
+		Err: fmt.Errorf("answer must be either %s, but %q", strings.Join(choiceLabels[:], ", "), ans)}
+}
+
+// the label and separator for the classification in the source page,
+// e.g. "分類 : テクノロジ系 » 技術要素 » データベース".
+const (
+	topicLabel     = "分類"
+	topicSeparator = "»"
+)
+
+// it returns the classification of the question. nil if not found.
+func parseTopic(doc *goquery.Document) []string {
+	var topic []string
+	doc.Find("div.main.kako *").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		// the separator is in the element itself, the topics may be links.
+		if !strings.Contains(ownText(s), topicSeparator) {
+			return true
+		}
+		text := s.Text()
+		if !(strings.Contains(text, topicLabel) || strings.Contains(s.Parent().Text(), topicLabel)) {
+			return true
+		}
+		if i := strings.Index(text, topicLabel); i >= 0 {
+			text = strings.TrimLeft(text[i+len(topicLabel):], " :：\n\t")
+		}
+		for _, t := range strings.Split(text, topicSeparator) {
+			if t = strings.TrimSpace(t); t != "" {
+				topic = append(topic, t)
+			}
+		}
+		return false
+	})
+	return topic
+}
+
+// it returns the text of s excluding the child elements.
+func ownText(s *goquery.Selection) string {
+	var text string
+	for _, n := range s.Nodes {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				text += c.Data
+			}
+		}
+	}
+	return text
+}
+
+// HasTopic returns whether the question is classified into the topic
+// at any level.
+func (res Response) HasTopic(topic string) bool {
+	for _, t := range res.Topic {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// explanation for each selection in the explanation section.
+var choiceExplanations = [...]string{"ul > li.lia", "ul > li.lii", "ul > li.liu", "ul > li.lie"}
+
+// parseChoices returns the selections with their explanations.
+func parseChoices(sel_doc, ansbg_doc *goquery.Selection, base *neturl.URL) []Choice {
+	var choices []Choice
+	sel_doc.Children().Filter("li").Each(func(i int, s *goquery.Selection) {
+		label := s.Find("a.selectBtn > button").Text()
+		if label == "" && i < len(choiceLabels) {
+			label = choiceLabels[i]
+		}
+		c := Choice{
+			Label:   label,
+			Content: parseBlocks(s.Find("div"), base),
+		}
+		for j, l := range choiceLabels {
+			if l == label {
+				c.Explanation = parseBlocks(ansbg_doc.Find(choiceExplanations[j]), base)
+			}
+		}
+		choices = append(choices, c)
+	})
+	return choices
+}
+
 // ParseHTML is helper funtion which parses html text and
 // converts to Response.
+// The text is decoded already, so that the charset declared in it is ignored.
 func ParseHTML(html string) (Response, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	doc, err := newDocument([]byte(html), unicode.UTF8)
 	if err != nil {
 		return Response{}, err
 	}
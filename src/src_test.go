@@ -1,30 +1,44 @@
 package src
 
 import (
+	"archive/zip"
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"math/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
 )
 
+// re-records the cassettes in testdata from the fake source server.
+var record = flag.Bool("record", false, "record the cassettes in testdata from the fake source server")
+
 func TestGetRandom(t *testing.T) {
-	t.Skip("Server Access is prevented")
-	// because the server stands outsider, accessing to the server should not be frequent.
+	u := newFakeUpstream(t)
+	g := NewGetter(u.source(FE), LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
 	const N = 10
-	const WAIT = 1
 	ctx := context.Background()
 	for i := 0; i < N; i++ {
-		time.Sleep(time.Duration(rand.Intn(WAIT)+1) * time.Second) // wait time for server access.
-		res, err := GetRandom(ctx, MaxQueryRange)
+		res, err := g.GetRandom(ctx, MaxQueryRange)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if len(res.URL) == 0 {
-			t.Fatal("empty response returned")
+		if !strings.HasPrefix(res.URL, u.URL+"/kakomon/") {
+			t.Fatalf("invalid URL: %s", res.URL)
 		}
 
 		var any_not_parsed = false
@@ -45,11 +59,25 @@ func TestGetRandom(t *testing.T) {
 			t.Errorf("not parsed URL: %s", res.URL)
 		}
 	}
+	if n := len(u.paths()); n != N {
+		t.Errorf("invalid number of requests, got: %d, want: %d", n, N)
+	}
 }
 
 func TestGet(t *testing.T) {
-	t.Skip("Server Access is prevented")
-	res, err := Get(context.Background(), Query{
+	// the page is replayed from the cassette, which is recorded by -record.
+	// The cassette is synthetic: it is recorded from the fixture served by
+	// the fake source server at upstreamURL, not from the real site.
+	mode, transport := RecorderReplay, http.RoundTripper(nil)
+	if *record {
+		mode, transport = RecorderRecord, newFakeUpstream(t).transport()
+	}
+	rec, err := NewRecorder(filepath.Join("testdata", "cassettes", "fe_28_haru_q2.json"), mode, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGetter(sourceAt(FE, upstreamURL), LeastIntervalTime, WithTransport(rec))
+	res, err := g.Get(context.Background(), Query{
 		Season: SeasonSpring,
 		Year:   28,
 		No:     2,
@@ -57,92 +85,1102 @@ func TestGet(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.URL != upstreamURL+"/kakomon/28_haru/q2.html" {
+		t.Errorf("invalid URL: %s", res.URL)
+	}
+	if res.Question == "" || len(res.Selections) != 4 || len(res.Choices) != 4 || res.Explanation == "" {
+		t.Errorf("not parsed: %+v", res)
+	}
+	if strings.Index("アイウエ", res.Answer) < 0 {
+		t.Errorf("invalid answer: %s", res.Answer)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	u := newFakeUpstream(t)
+	s := u.source(FE)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	q := Query{EraHeisei, 28, SeasonSpring, 2}
+	limiter := WithRateLimiter(NewRateLimiter(time.Millisecond, 1))
+
+	rec, err := NewRecorder(path, RecorderRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewGetter(s, LeastIntervalTime, WithTransport(rec), limiter).Get(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
 
-	fpout, err := os.Create("test_get.txt")
-	defer fpout.Close()
+	// replayed without the source server.
+	u.Close()
+	if rec, err = NewRecorder(path, RecorderReplay, nil); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGetter(s, LeastIntervalTime, WithTransport(rec), limiter, WithRetry(0, 0))
+	got, err := g.Get(context.Background(), q)
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Fprintf(fpout, "Question:\n%v\nSelections:\n%v\nAnswer:\n%v\nExplanation:\n%v\n",
-		res.Question, res.Selections, res.Answer, res.Explanation)
+	if got.Question != want.Question || got.Answer != want.Answer || got.Question == "" {
+		t.Errorf("replayed response differs, got: %+v, want: %+v", got, want)
+	}
+	q.No = 3
+	if _, err := g.Get(context.Background(), q); err == nil {
+		t.Error("request not recorded must be error")
+	}
+	if _, err := NewRecorder(filepath.Join(t.TempDir(), "none.json"), RecorderReplay, nil); err == nil {
+		t.Error("replaying missing cassette must be error")
+	}
 }
 
 func TestRandomQuery(t *testing.T) {
 	const randomN = 100
 	for i := 0; i < randomN; i++ {
-		q := randomQuery(FE.QueryRange)
-		if s := q.Season; s != seasonRange[0] && s != seasonRange[1] {
+		q, err := FE.randomQuery(FE.QueryRange, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := regularSeason(q.Season); s != seasonRange[0] && s != seasonRange[1] {
 			t.Errorf("invaid season, got: %s", s)
 		}
-		if y := q.Year; y < yearRange[0] || y > yearRange[1] {
+		if y := q.WesternYear(); y < MinYear || y > MaxYear {
 			t.Errorf("invaid year, got: %v", y)
 		}
 		if n := q.No; n < noRange[0] || n > noRange[1] {
 			t.Errorf("invaid number, got: %v", n)
 		}
 	}
+
+	// the built-in source does not select the sessions after MaxYear.
+	q, _ := WesternQuery(MaxYear+1, SeasonSpring, 1)
+	if err := FE.Validates(q); err == nil {
+		t.Errorf("session after MaxYear must not be accepted, got: %v", q)
+	}
+
+	// zero MaxYear accepts the sessions up to the current year.
+	s := FE
+	s.MaxYear = 0
+	q, ok := WesternQuery(time.Now().Year(), SeasonAutumn, 1)
+	if !ok {
+		t.Fatal("current year must be valid")
+	}
+	if err := s.Validates(q); err != nil {
+		t.Errorf("session in the current year must be accepted, got: %v", err)
+	}
+	q.Year++
+	if err := s.Validates(q); err == nil {
+		t.Error("session in the next year must not be accepted")
+	}
+}
+
+func TestEra(t *testing.T) {
+	for _, c := range []struct {
+		western int
+		season  string
+		want    Query
+		path    string
+	}{
+		{2016, SeasonSpring, Query{EraHeisei, 28, SeasonSpring, 2}, "28_haru"},
+		{2019, SeasonSpring, Query{EraHeisei, 31, SeasonSpring, 2}, "31_haru"},
+		{2019, SeasonAutumn, Query{EraReiwa, 1, SeasonAutumn, 2}, "r01_aki"},
+		{2020, SeasonAutumn, Query{EraReiwa, 2, SeasonOctober, 2}, "02_oct"},
+		{2022, SeasonSpring, Query{EraReiwa, 4, SeasonSpring, 2}, "04_haru"},
+	} {
+		q, ok := WesternQuery(c.western, c.season, 2)
+		if !ok || q != c.want {
+			t.Errorf("%d %s: invalid query, got: %v", c.western, c.season, q)
+			continue
+		}
+		if y := q.WesternYear(); y != c.western {
+			t.Errorf("%v: invalid western year, got: %d", q, y)
+		}
+		if err := FE.Validates(q); err != nil {
+			t.Errorf("%v: %v", q, err)
+		}
+		url, err := GenerateURL(q)
+		if want := "http://www.fe-siken.com/kakomon/" + c.path + "/q2.html"; err != nil || url != want {
+			t.Errorf("%v: invalid URL, got: %s, want: %s", q, url, want)
+		}
+	}
+	if _, ok := WesternQuery(2020, SeasonSpring, 1); ok {
+		t.Error("cancelled session must not be returned")
+	}
+
+	if q := (Query{Year: 2019, Season: SeasonAutumn, No: 1}).Normalize(); q != (Query{EraReiwa, 1, SeasonAutumn, 1}) {
+		t.Errorf("western year must be converted, got: %v", q)
+	}
+	if q := (Query{Year: 28, Season: SeasonSpring, No: 1}).Normalize(); q.Era != EraHeisei {
+		t.Errorf("empty era must be Heisei, got: %v", q)
+	}
+	if p := LocalPath(Query{EraReiwa, 1, SeasonAutumn, 3}); p != filepath.Join("r01_aki", "q3") {
+		t.Errorf("invalid local path, got: %s", p)
+	}
+
+	for _, q := range []Query{
+		{EraHeisei, 31, SeasonAutumn, 1},
+		{EraReiwa, 1, SeasonSpring, 1},
+		{EraReiwa, 2, SeasonSpring, 1},
+		{EraReiwa, 2, SeasonAutumn, 1},
+		{EraReiwa, 3, SeasonOctober, 1},
+		{"s", 60, SeasonSpring, 1},
+	} {
+		if err := FE.Validates(q); err == nil {
+			t.Errorf("%v: invalid session must be error", q)
+		}
+	}
+}
+
+func TestSessionCatalogue(t *testing.T) {
+	s := FE
+	s.MaxYear = 2023
+	s.Sessions = []Session{
+		{Era: EraHeisei, Year: 28, Season: SeasonSpring},
+		{Year: 2023, Season: "koukai", MaxNo: 60},
+	}
+	if err := s.ValidatesSelf(); err != nil {
+		t.Fatal(err)
+	}
+	sample := Query{EraReiwa, 5, "koukai", 60}
+	if err := s.Validates(sample); err != nil {
+		t.Error(err)
+	}
+	for _, q := range []Query{
+		{EraReiwa, 5, "koukai", 61},
+		{EraHeisei, 28, SeasonAutumn, 1},
+	} {
+		if err := s.Validates(q); err == nil {
+			t.Errorf("%v: query out of the catalogue must be error", q)
+		}
+	}
+
+	url := newURLGenerator(s)
+	for i := 0; i < 50; i++ {
+		q, err := url.RandomQuery(MaxQueryRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Validates(q); err != nil {
+			t.Errorf("%v: %v", q, err)
+		}
+	}
+	if u, err := url.Generate(sample); err != nil || u != "http://www.fe-siken.com/kakomon/05_koukai/q60.html" {
+		t.Errorf("invalid URL, got: %s, %v", u, err)
+	}
+	if n := len(s.queries(s.QueryRange)); n != 80+60 {
+		t.Errorf("invalid number of queries, got: %d", n)
+	}
+
+	qr := s.QueryRange
+	qr.MinNo = 61
+	if _, err := url.RandomQuery(qr); err != nil {
+		t.Errorf("spring session has the questions over 60, got: %v", err)
+	}
+	qr.Season = SeasonAutumn
+	if _, err := url.RandomQuery(qr); err == nil {
+		t.Error("no session in the range must be error")
+	}
+
+	for _, sessions := range [][]Session{
+		{{Era: EraReiwa, Year: 1, Season: SeasonSpring}},
+		{{Year: 2016, Season: SeasonSpring}, {Era: EraHeisei, Year: 28, Season: SeasonSpring}},
+		{{Year: 2016, Season: SeasonSpring, MaxNo: 81}},
+	} {
+		s.Sessions = sessions
+		if err := s.ValidatesSelf(); err == nil {
+			t.Errorf("%v: invalid catalogue must be error", sessions)
+		}
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	var requests, failures int
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failures > 0 {
+			failures--
+			w.WriteHeader(status)
+			return
+		}
+		writeShiftJIS(t, w, structuredPage)
+	}))
+	defer ts.Close()
+
+	s := FE
+	s.URL = ts.URL + "/{{sikenYear .}}_{{.Season}}/q{{.No}}.html"
+	g := NewGetter(s, LeastIntervalTime,
+		WithRateLimiter(NewRateLimiter(time.Millisecond, 1)),
+		WithRetry(2, time.Millisecond),
+		WithCircuitBreaker(2, time.Hour),
+	)
+	ctx := context.Background()
+	q := Query{EraHeisei, 28, SeasonSpring, 1}
+
+	// the temporary failures are retried.
+	requests, failures, status = 0, 2, http.StatusServiceUnavailable
+	if _, err := g.Get(ctx, q); err != nil {
+		t.Fatalf("retried request must succeed, got: %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("invalid number of requests, got: %d, want: 3", requests)
+	}
+
+	// not found is not retried, and not counted as the failure.
+	requests, failures, status = 0, 1, http.StatusNotFound
+	_, err := g.Get(ctx, q)
+	var ferr *FetchError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &ferr) || ferr.StatusCode != http.StatusNotFound {
+		t.Errorf("404 must be ErrNotFound, got: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("not found must not be retried, got: %d requests", requests)
+	}
+	if st := g.UpstreamStatus(); st.State != BreakerClosed {
+		t.Errorf("breaker must be closed, got: %+v", st)
+	}
+
+	// the other client errors are not retried, and keep the status.
+	requests, failures, status = 0, 1, http.StatusForbidden
+	_, err = g.Get(ctx, q)
+	if !errors.Is(err, ErrRejected) || errors.Is(err, ErrNotFound) || !errors.As(err, &ferr) || ferr.StatusCode != http.StatusForbidden {
+		t.Errorf("403 must be ErrRejected, got: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("rejected request must not be retried, got: %d requests", requests)
+	}
+
+	// the breaker is opened by the consecutive failures, and fails fast.
+	failures, status = 100, http.StatusInternalServerError
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(ctx, q); !errors.Is(err, ErrUnavailable) {
+			t.Errorf("5xx must be ErrUnavailable, got: %v", err)
+		}
+	}
+	if st := g.UpstreamStatus(); st.State != BreakerOpen || st.Failures != 2 || st.LastError == "" {
+		t.Errorf("breaker must be open, got: %+v", st)
+	}
+	requests = 0
+	if _, err := g.Get(ctx, q); !errors.Is(err, ErrUnavailable) || !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("open breaker must fail fast, got: %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("open breaker must not request, got: %d requests", requests)
+	}
+}
+
+func TestDiscoverSessions(t *testing.T) {
+	pages := map[string]string{
+		"/kakomon/": `<a href="28_haru/">H28春</a><a href="r01_aki/index.html">R1秋</a>
+<a href="/kakomon/02_oct/">R2</a><a href="http://example.com/27_aki/">other</a><a href="../">top</a>
+<a href="29_haru/">H29春</a>`,
+		"/kakomon/28_haru/":           `<a href="q1.html">1</a><a href="q80.html#top">80</a><a href="../">back</a>`,
+		"/kakomon/r01_aki/index.html": `<a href="q1.html">1</a><a href="q60.html">60</a>`,
+		"/kakomon/02_oct/":            `no question`,
+	}
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeShiftJIS(t, w, "<html><body>"+page+"</body></html>")
+	}))
+	defer ts.Close()
+
+	s := FE
+	s.URL = ts.URL + "/kakomon/{{sikenYear .}}_{{.Season}}/q{{.No}}.html"
+	s.IndexURL = ts.URL + "/kakomon/"
+	s.MinYear, s.MaxYear = 2016, 2016
+	g := NewGetter(s, LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+
+	// the session which can not be counted is skipped.
+	sessions, err := g.UpdateSessions(context.Background())
+	var derr *DiscoverError
+	if !errors.As(err, &derr) || len(derr.Failures) != 1 || derr.Failures[ts.URL+"/kakomon/29_haru/"] == nil {
+		t.Fatalf("the failed session must be reported, got: %v", err)
+	}
+	want := []Session{
+		{Era: EraHeisei, Year: 28, Season: SeasonSpring, MaxNo: 80},
+		{Era: EraReiwa, Year: 1, Season: SeasonAutumn, MaxNo: 60},
+	}
+	if fmt.Sprint(sessions) != fmt.Sprint(want) {
+		t.Fatalf("invalid sessions, got: %v, want: %v", sessions, want)
+	}
+
+	// the source range is extended to the catalogue.
+	src := g.Source()
+	if src.MinYear != 2016 || src.MaxYear != 2019 {
+		t.Errorf("invalid year range, got: [%d:%d]", src.MinYear, src.MaxYear)
+	}
+	for i := 0; i < 20; i++ {
+		q, err := g.RandomQuery(MaxQueryRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Era == EraReiwa && q.No > 60 {
+			t.Errorf("question out of the session, got: %v", q)
+		}
+	}
+
+	// the known sessions are not fetched again.
+	requested = nil
+	if _, err := g.UpdateSessions(context.Background()); !errors.As(err, &derr) {
+		t.Fatal(err)
+	}
+	sort.Strings(requested)
+	if got := fmt.Sprint(requested); got != "[/kakomon/ /kakomon/02_oct/ /kakomon/29_haru/]" {
+		t.Errorf("known sessions must not be fetched, got: %v", got)
+	}
+}
+
+func TestQueryRangeQueries(t *testing.T) {
+	qr := QueryRange{MaxYear: 29, MinYear: 28, MaxNo: 3, MinNo: 1, Season: SeasonAll}
+	qs := FE.queries(qr)
+	if n := len(qs); n != 2*2*3 {
+		t.Fatalf("invalid number of queries, got: %d", n)
+	}
+	if q := qs[0]; q != (Query{EraHeisei, 28, SeasonSpring, 1}) {
+		t.Errorf("invalid first query, got: %v", q)
+	}
+	if p := LocalPath(qs[len(qs)-1]); p != "29_aki/q3" {
+		t.Errorf("invalid local path, got: %s", p)
+	}
+}
+
+func TestCategory(t *testing.T) {
+	s := FE
+	s.Categories = append([]Category{
+		{Name: CategoryTechnology, Field: "基礎理論", MinNo: 1, MaxNo: 10},
+		{Name: CategoryTechnology, Field: "コンピュータシステム", MinNo: 11, MaxNo: 50},
+	}, defaultCategories[1:]...)
+	if err := s.ValidatesSelf(); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := s.CategoryOf(55); !ok || c.Name != CategoryManagement {
+		t.Errorf("invalid category for 55, got: %v", c)
+	}
+
+	url := newURLGenerator(s)
+	for _, name := range []string{CategoryTechnology, "基礎理論", CategoryStrategy} {
+		qr := s.QueryRange
+		qr.Category = name
+		for i := 0; i < 20; i++ {
+			q, err := url.RandomQuery(qr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.Contains(qr, q) {
+				t.Errorf("%s: query out of category, got: %v", name, q)
+			}
+		}
+	}
+
+	qr := s.QueryRange
+	qr.Category = "unknown"
+	if _, err := url.RandomQuery(qr); err == nil {
+		t.Error("unknown category must be error")
+	}
+	qr.Category, qr.MaxNo = CategoryStrategy, 50
+	if _, err := url.RandomQuery(qr); err == nil {
+		t.Error("category out of range must be error")
+	}
+
+	s.Categories = append(s.Categories, Category{Name: "overlap", MinNo: 80, MaxNo: 80})
+	if err := s.ValidatesSelf(); err == nil {
+		t.Error("overlapped categories must be error")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	idx := NewIndex()
+	db := Query{EraHeisei, 28, SeasonSpring, 1}
+	nw := Query{EraHeisei, 28, SeasonAutumn, 2}
+	idx.Add(db, Response{
+		Question:    "関係データベースの正規化に関する記述のうち，適切なものはどれか。",
+		Selections:  []string{"ア: 第１正規形", "イ: 第２正規形"},
+		Explanation: "ＳＱＬの解説",
+		Topic:       []string{"テクノロジ系", "データベース"},
+	})
+	idx.Add(nw, Response{
+		Question:    "ＴＣＰ／ＩＰネットワークのルーティングに関する記述はどれか。",
+		Explanation: "データベースとは関係ない。",
+	})
+
+	hits, total := idx.Search("データベース", 10)
+	if total != 2 || hits[0].Query != db {
+		t.Fatalf("question field must be ranked first, got: %v", hits)
+	}
+	if want := "関係データベースの正規化"; !strings.Contains(hits[0].Snippet, want) {
+		t.Errorf("snippet must contain %s, got: %s", want, hits[0].Snippet)
+	}
+	// width and case are folded.
+	if hits, _ := idx.Search("sql", 10); len(hits) != 1 || hits[0].Query != db {
+		t.Errorf("folded text must be matched, got: %v", hits)
+	}
+	// all of the words must be contained.
+	if hits, _ := idx.Search("正規形 ネットワーク", 10); len(hits) != 0 {
+		t.Errorf("no question must be matched, got: %v", hits)
+	}
+	if hits, _ := idx.Search("木", 10); len(hits) != 0 {
+		t.Errorf("no question must be matched, got: %v", hits)
+	}
+
+	if qs := idx.Topic("データベース"); len(qs) != 1 || qs[0] != db {
+		t.Errorf("question of the topic must be returned, got: %v", qs)
+	}
+
+	// replaced question is not matched by the old text.
+	idx.Add(db, Response{Question: "木構造"})
+	if qs := idx.Topic("データベース"); len(qs) != 0 {
+		t.Errorf("replaced question must not be in the old topic, got: %v", qs)
+	}
+	if hits, _ := idx.Search("正規化", 10); len(hits) != 0 {
+		t.Errorf("replaced question must not be matched, got: %v", hits)
+	}
+	if hits, _ := idx.Search("木", 10); len(hits) != 1 {
+		t.Errorf("single character must be matched, got: %v", hits)
+	}
+	if n := idx.Len(); n != 2 {
+		t.Errorf("invalid number of questions, got: %d", n)
+	}
 }
 
-func TestParseDoc(t *testing.T) {
-	doc, err := goqueryDocFile("./y28_spring_q2.html")
+func TestLocalFetcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feserver-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := Query{EraHeisei, 28, SeasonSpring, 2}
+	want := Response{Question: "question", Answer: "ア", Version: JSONVersion}
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// local directory tree
+	treeDir := filepath.Join(dir, "tree")
+	file := filepath.Join(treeDir, LocalPath(q)+ExtJSON)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// zip archive with the top-level directory.
+	zipFile := filepath.Join(dir, "tree.zip")
+	fp, err := os.Create(zipFile)
 	if err != nil {
 		t.Fatal(err)
 	}
+	zw := zip.NewWriter(fp)
+	w, err := zw.Create("mirror/" + filepath.ToSlash(LocalPath(q)) + ExtJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	zw.Close()
+	fp.Close()
+
+	for _, path := range []string{treeDir, zipFile} {
+		f := NewLocalFetcher(FE, path)
+		cache := NewMemoryCache(10, 0)
+		g := NewGetter(FE, LeastIntervalTime, WithFetcher(f), WithCache(cache))
+		if res, ok := g.GetStored(context.Background(), q); !ok || res.Question != want.Question {
+			t.Errorf("%s: stored question must be found, got: %+v", path, res)
+		}
+		res, err := g.Get(context.Background(), q)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if res.Question != want.Question || res.Answer != want.Answer {
+			t.Errorf("%s: unexpected response: %+v", path, res)
+		}
+		if _, err := g.Get(context.Background(), Query{EraHeisei, 28, SeasonSpring, 3}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: missing question must be ErrNotFound, got: %v", path, err)
+		}
+		if stats := cache.Stats(); stats != (CacheStats{}) {
+			t.Errorf("%s: local dataset must not be cached, got: %+v", path, stats)
+		}
+		f.Close()
+	}
+
+	// the question of the session found later, and of the old JSONVersion.
+	discovered := Query{EraReiwa, 5, "koukai", 1}
+	stale := Query{EraHeisei, 28, SeasonSpring, 4}
+	old := want
+	old.Version = "1.0.0"
+	for q, res := range map[Query]Response{discovered: want, stale: old} {
+		data, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(treeDir, LocalPath(q)+ExtJSON)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := NewGetter(FE, LeastIntervalTime, WithFetcher(NewLocalFetcher(FE, treeDir)))
+	if _, err := g.Get(context.Background(), stale); !errors.Is(err, ErrParse) {
+		t.Errorf("old JSONVersion must be ErrParse, got: %v", err)
+	}
+	sessions := []Session{{Era: EraHeisei, Year: 28, Season: SeasonSpring}, {Year: 2023, Season: "koukai", MaxNo: 60}}
+	if err := g.url.setSessions(sessions); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := g.Get(context.Background(), discovered); err != nil || res.Question != want.Question {
+		t.Errorf("question of the session found later must be served, got: %+v, %v", res, err)
+	}
 
-	res, err := parseDoc(doc)
+	// the LocalFetcher shared by the Getters is not changed by them.
+	f := NewLocalFetcher(FE, treeDir)
+	other := FE
+	other.URL = "http://example.com/{{.Year}}_{{.Season}}/q{{.No}}.html"
+	getters := []*Getter{
+		NewGetter(FE, LeastIntervalTime, WithFetcher(f)),
+		NewGetter(other, LeastIntervalTime, WithFetcher(f)),
+	}
+	for _, g := range append(getters, &Getter{url: f.url, fetcher: f}) {
+		wantURL, _ := g.url.Generate(q)
+		if page, err := g.fetcher.Fetch(context.Background(), q); err != nil || page.URL != wantURL {
+			t.Errorf("page must have the URL of the Getter %s, got: %s, %v", wantURL, page.URL, err)
+		}
+	}
+}
+
+func TestMirrorStaleVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feserver-mirror")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
+
+	u := newFakeUpstream(t)
+	g := NewGetter(u.source(FE), LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+	m := &Mirror{Getter: g, Dir: dir}
+	qr := QueryRange{MinYear: 28, MaxYear: 28, MinNo: 1, MaxNo: 2, Season: SeasonSpring}
+	if report, err := m.Run(context.Background(), qr); err != nil || report.Saved != 2 {
+		t.Fatalf("questions must be saved, got: %+v, %v", report, err)
+	}
+
+	// the question of the old JSONVersion is saved again.
+	stale := filepath.Join(dir, LocalPath(Query{EraHeisei, 28, SeasonSpring, 1})+ExtJSON)
+	if err := ioutil.WriteFile(stale, []byte(`{"version":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := m.Run(context.Background(), qr)
+	if err != nil || report.Saved != 1 || report.Skipped != 1 {
+		t.Errorf("only the question of the old version must be saved again, got: %+v, %v", report, err)
+	}
+	if data, err := ioutil.ReadFile(stale); err != nil || jsonVersion(data) != JSONVersion {
+		t.Errorf("the question must be saved in the current version, got: %s, %v", data, err)
+	}
+}
+
+func TestGetterHTTPOptions(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		writeShiftJIS(t, w, structuredPage)
+	}))
+	defer ts.Close()
+
+	s := FE
+	s.URL = ts.URL + "/{{.Year}}_{{.Season}}/q{{.No}}.html"
+	g := NewGetter(s, LeastIntervalTime,
+		WithUserAgent("feserver-test"),
+		WithHeader("X-Test", "value"),
+		WithTimeout(time.Second),
+	)
+	if _, err := g.Get(context.Background(), Query{EraHeisei, 28, SeasonSpring, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if ua := got.Get("User-Agent"); ua != "feserver-test" {
+		t.Errorf("invalid User-Agent, got: %s", ua)
+	}
+	if v := got.Get("X-Test"); v != "value" {
+		t.Errorf("invalid header, got: %s", v)
+	}
+}
+
+func TestGetImageLimited(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		switch path.Ext(r.URL.Path) {
+		case ".html":
+			w.Write([]byte(structuredPage))
+		case ".png":
+			w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		default:
+			w.Write([]byte("<html><body>not an image</body></html>"))
+		}
+	}))
+	defer ts.Close()
+
+	g := NewGetter(sourceAt(FE, ts.URL), LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Hour, 1)))
+	if _, err := g.Get(context.Background(), Query{EraHeisei, 28, SeasonSpring, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the image in the response is requested without waiting for the interval of the questions.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	img, err := g.GetImage(ctx, ts.URL+"/kakomon/28_haru/img/02.png")
+	if err != nil {
+		t.Fatalf("image must be requested immediately, got: %v", err)
+	}
+	if img.ContentType != "image/png" {
+		t.Errorf("invalid content type, got: %s", img.ContentType)
+	}
+
+	// the other images wait for the interval.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetImage(ctx, ts.URL+"/kakomon/28_haru/img/03.png"); err != context.DeadlineExceeded {
+		t.Errorf("image not in the responses must wait for the interval, got: %v", err)
+	}
+
+	// the locations other than the images are not requested.
+	n := len(requests)
+	for _, loc := range []string{"/kakomon/28_haru/q3.html", "/kakomon/28_haru/?img=02.png"} {
+		if _, err := g.GetImage(context.Background(), ts.URL+loc); !errors.Is(err, ErrNotFound) || !errors.Is(err, ErrNotImage) {
+			t.Errorf("%s: must be ErrNotImage, got: %v", loc, err)
+		}
+	}
+	if len(requests) != n {
+		t.Errorf("the locations other than the images must not be requested, got: %v", requests[n:])
+	}
+
+	// the content other than the image is not served.
+	g = NewGetter(sourceAt(FE, ts.URL), LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+	if _, err := g.GetImage(context.Background(), ts.URL+"/fake.gif"); !errors.Is(err, ErrNotImage) {
+		t.Errorf("content other than the image must be ErrNotImage, got: %v", err)
+	}
+}
+
+func TestGetImageTooLarge(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, maxBodySize)...)
+		if r.URL.Path == "/chunked.png" {
+			// without Content-Length.
+			w.Write(data[:1])
+			w.(http.Flusher).Flush()
+			data = data[1:]
+		}
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	g := NewGetter(sourceAt(FE, ts.URL), LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+	for _, name := range []string{"/large.png", "/chunked.png"} {
+		if _, err := g.GetImage(context.Background(), ts.URL+name); !errors.Is(err, ErrTooLarge) || !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: image over the limit must be ErrTooLarge, got: %v", name, err)
+		}
+	}
+}
+
+func TestGetImageCache(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer ts.Close()
+
+	cache, images := NewMemoryCache(10, 0), NewMemoryCache(10, 0)
+	g := NewGetter(sourceAt(FE, ts.URL), LeastIntervalTime,
+		WithRateLimiter(NewRateLimiter(time.Millisecond, 1)), WithCache(cache), WithImageCache(images))
+	for i := 0; i < 2; i++ {
+		if _, err := g.GetImage(context.Background(), ts.URL+"/kakomon/28_haru/img/02.png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := images.Stats(); s.Entries != 1 || s.Hits != 1 {
+		t.Errorf("image must be cached in the image cache, got: %+v", s)
+	}
+	if s := g.CacheStats(); s != (CacheStats{}) {
+		t.Errorf("image must not be counted in the question cache, got: %+v", s)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	l := NewRateLimiter(interval, 1)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*interval {
+		t.Errorf("requests must be limited, elapsed: %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := NewRateLimiter(time.Hour, 1).Wait(ctx); err != nil {
+		t.Fatal("first request must be allowed immediately")
+	}
+	l = NewRateLimiter(time.Hour, 1)
+	l.Wait(ctx)
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("waiting must be canceled by context, got: %v", err)
+	}
+}
+
+func TestRateLimiterVariation(t *testing.T) {
+	const interval = time.Hour
+	l := NewRateLimiter(interval, 1)
+	l.variation = interval
+
+	// the variation never makes the requests sooner than the interval
+	// after the previous one, including its variation.
+	var prev time.Time
+	for i := 0; i < 20; i++ {
+		act := l.reserve().act
+		if i > 0 && act.Before(prev.Add(interval-time.Second)) {
+			t.Fatalf("request %d must wait at least %v after the previous one, got: %v", i, interval, act.Sub(prev))
+		}
+		prev = act
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	const interval = time.Hour
+	l := NewRateLimiter(interval, 1)
+	l.reserve()
+	b := l.reserve()
+	c := l.reserve()
+
+	// the token of b is not given back, since c is reserved after it.
+	l.cancel(b)
+	d := l.reserve()
+	if d.act.Before(c.act.Add(interval - time.Second)) {
+		t.Errorf("canceled token must not be reused with the later reservation, got: %v after c", d.act.Sub(c.act))
+	}
 
-	fpout, err := os.Create("test_parse_doc.txt")
-	defer fpout.Close()
+	// the token of the latest reservation is given back.
+	l.cancel(d)
+	if e := l.reserve(); e.act.Sub(d.act) > time.Second || d.act.Sub(e.act) > time.Second {
+		t.Errorf("canceled latest token must be given back, got: %v after d", e.act.Sub(d.act))
+	}
+}
+
+func TestHostLimiterShared(t *testing.T) {
+	a := hostLimiter("www.example.com", LeastIntervalTime)
+	b := hostLimiter("www.example.com", 2*LeastIntervalTime)
+	if a != b {
+		t.Fatal("limiter must be shared by the same host")
+	}
+	if a.interval != 2*LeastIntervalTime {
+		t.Errorf("limiter must have the longest interval, got: %v", a.interval)
+	}
+	if c := hostLimiter("www.example.org", LeastIntervalTime); c == a {
+		t.Error("limiter must not be shared by the different hosts")
+	}
+}
+
+// minimal page in the layout of fe-siken.com.
+const structuredPage = `<html><body><div class="main kako">
+<h3 class="qno">問2</h3>
+<div>次の表を見よ。<br>
+<img src="img/02.png" alt="図">
+<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>
+<pre>x ← x + 1</pre>
+</div>
+<div class="ansbg"><ul class="selectList cf">
+<li><a class="selectBtn"><button>ア</button></a><div>選択肢A</div></li>
+<li><a class="selectBtn"><button>イ</button></a><div>選択肢B</div></li>
+<li><a class="selectBtn"><button>ウ</button></a><div>選択肢C</div></li>
+<li><a class="selectBtn"><button>エ</button></a><div>選択肢D</div></li>
+</ul></div>
+<div class="answerBox"><span id="answerChar">イ</span></div>
+<h3>解説</h3>
+<div class="ansbg">全体の解説<ul>
+<li class="lia">Aの解説</li><li class="lii">Bの解説</li><li class="liu">Cの解説</li><li class="lie">Dの解説</li>
+</ul></div>
+<div class="ansbg"><h3>分類</h3>
+<p><a href="#">テクノロジ系</a> &raquo; <a href="#">技術要素</a> &raquo; <a href="#">データベース</a></p></div>
+</div></body></html>`
+
+func TestParseStructured(t *testing.T) {
+	const url = "http://www.fe-siken.com/kakomon/28_haru/q2.html"
+	res, err := parseHTML(url, shiftJIS(t, structuredPage), ParserFunc(parseDoc), japanese.ShiftJIS)
 	if err != nil {
 		t.Fatal(err)
 	}
-	fmt.Fprintf(fpout, "Question:\n%v\nSelections:\n%v\nAnswer:\n%v\nExplanation:\n%v\n",
-		res.Question, res.Selections, res.Answer, res.Explanation)
+
+	types := make([]string, 0, len(res.Body))
+	for _, b := range res.Body {
+		types = append(types, b.Type)
+	}
+	if got, want := fmt.Sprint(types), fmt.Sprint([]string{BlockParagraph, BlockImage, BlockTable, BlockCode}); got != want {
+		t.Fatalf("invalid block types, got: %v, want: %v", got, want)
+	}
+	if src := res.Body[1].Src; src != "http://www.fe-siken.com/kakomon/28_haru/img/02.png" {
+		t.Errorf("image URL must be absolute, got: %s", src)
+	}
+	if rows := res.Body[2].Rows; len(rows) != 2 || rows[1][1] != "2" {
+		t.Errorf("invalid table rows, got: %v", rows)
+	}
+
+	if n := len(res.Choices); n != 4 {
+		t.Fatalf("invalid number of choices, got: %d", n)
+	}
+	c := res.Choices[1]
+	if c.Label != "イ" || c.Content[0].Text != "選択肢B" || c.Explanation[0].Text != "Bの解説" {
+		t.Errorf("invalid choice, got: %+v", c)
+	}
+	if res.Answer != "イ" || res.Version != JSONVersion {
+		t.Errorf("invalid flat fields, got answer: %s, version: %s", res.Answer, res.Version)
+	}
+	if got := fmt.Sprint(res.Topic); got != "[テクノロジ系 技術要素 データベース]" || !res.HasTopic("データベース") {
+		t.Errorf("invalid topic, got: %v", got)
+	}
 }
 
-func TestParseDocHasImage(t *testing.T) {
-	doc, err := goqueryDocFile("./y19_spring_q26.html")
+// minimal page of the question divided into the parts.
+const multipartPage = `<html><body><div class="main kako">
+<h3 class="qno">問1</h3>
+<div>ネットワークに関する次の記述を読んで，設問に答えよ。</div>
+<div>設問1 a に入れる字句を答えよ。<img src="img/01.png"></div>
+<div class="answerBox">設問1 a:ルータ</div>
+<h3>解説</h3>
+<div class="ansbg">aはルータである。</div>
+</div></body></html>`
+
+func TestParser(t *testing.T) {
+	const url = "http://www.nw-siken.com/kakomon/28_aki/pm1_1.html"
+	p, ok := LookupParser(ParserMultipart)
+	if !ok {
+		t.Fatal("multipart parser must be registered")
+	}
+	res, err := parseHTML(url, shiftJIS(t, multipartPage), p, japanese.ShiftJIS)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !strings.Contains(res.Question, "設問1") || res.Answer != "設問1 a:ルータ" || len(res.Selections) != 0 {
+		t.Errorf("invalid multipart response: %+v", res)
+	}
+	if !res.HasImage || res.Explanation != "aはルータである。" || res.URL != url {
+		t.Errorf("invalid multipart response: %+v", res)
+	}
+	// the multipart page is not the default layout.
+	if _, err := parseHTML(url, shiftJIS(t, multipartPage), ParserFunc(parseDoc), japanese.ShiftJIS); !errors.Is(err, ErrParse) {
+		t.Errorf("default parser must fail for multipart page, got: %v", err)
+	}
+
+	// the source names the parser in the registry.
+	RegisterParser("test-title", ParserFunc(func(doc *goquery.Document) (Response, error) {
+		return Response{Question: doc.Find("title").Text(), Version: JSONVersion}, nil
+	}))
+	t.Cleanup(func() {
+		// the registry is global, so that the test can run again by -count.
+		parsersMu.Lock()
+		delete(parsers, "test-title")
+		parsersMu.Unlock()
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeShiftJIS(t, w, "<html><head><title>question</title></head></html>")
+	}))
+	defer ts.Close()
+	s := FE
+	s.URL = ts.URL + "/{{sikenYear .}}_{{.Season}}/q{{.No}}.html"
+	s.Parser = "test-title"
+	if err := s.ValidatesSelf(); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGetter(s, LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+	res, err = g.Get(context.Background(), Query{EraHeisei, 28, SeasonSpring, 1})
+	if err != nil || res.Question != "question" {
+		t.Errorf("registered parser must be used, got: %+v, %v", res, err)
+	}
+	s.Parser = "unknown"
+	if err := s.ValidatesSelf(); err == nil {
+		t.Error("unknown parser must be error")
+	}
+}
 
-	res, err := parseDoc(doc)
+func TestRules(t *testing.T) {
+	const page = `<html><head><meta charset="utf-8"></head><body>
+<p class="q">次のうち，素数はどれか。<img src="q.png"></p>
+<ol><li><b>A</b> <span>4</span></li><li><b>B</b> <span>5</span></li><li><b>C</b> <span>6</span></li></ol>
+<p>正解: <em class="ans">B</em></p><div class="exp">5は素数である。</div>
+</body></html>`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, page)
+	}))
+	defer ts.Close()
+
+	s := FE
+	s.URL = ts.URL + "/{{western .}}/{{.Season}}/{{.No}}.html"
+	s.Encoding = "utf-8"
+	s.Rules = &Rules{
+		Question:       "p.q",
+		Selections:     "ol > li",
+		SelectionLabel: "b",
+		SelectionText:  "span",
+		Answer:         "em.ans",
+		Explanation:    "div.exp",
+	}
+	if err := s.ValidatesSelf(); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGetter(s, LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+	res, err := g.Get(context.Background(), Query{EraHeisei, 28, SeasonSpring, 1})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if res.Question != "次のうち，素数はどれか。" || res.Answer != "B" || !res.HasImage {
+		t.Errorf("invalid response: %+v", res)
+	}
+	if got := fmt.Sprint(res.Selections); got != "[A: 4 B: 5 C: 6]" {
+		t.Errorf("invalid selections, got: %v", got)
+	}
+	if len(res.Choices) != 3 || res.Choices[1].Label != "B" || res.Explanation != "5は素数である。" {
+		t.Errorf("invalid choices or explanation: %+v", res)
+	}
+
+	// the answer must be the label of the selections.
+	s.Rules.Answer = "div.exp"
+	g = NewGetter(s, LeastIntervalTime, WithRateLimiter(NewRateLimiter(time.Millisecond, 1)))
+	var perr *ParseError
+	if _, err := g.Get(context.Background(), Query{EraHeisei, 28, SeasonSpring, 2}); !errors.As(err, &perr) || perr.Selector != "div.exp" {
+		t.Errorf("invalid answer must be ParseError, got: %v", err)
+	}
 
-	if res.HasImage {
-		t.Fatal("must not have some image, but HasImage is true")
+	for _, invalid := range []func(*Source){
+		func(s *Source) { s.Rules = &Rules{Selections: "li", Answer: "em"} },
+		func(s *Source) { s.Rules = &Rules{Question: "p[", Selections: "li", Answer: "em"} },
+		func(s *Source) { s.Parser = ParserMultipart },
+		func(s *Source) { s.Encoding = "unknown" },
+	} {
+		s := s
+		s.Rules = &Rules{Question: "p", Selections: "li", Answer: "em"}
+		invalid(&s)
+		if err := s.ValidatesSelf(); err == nil {
+			t.Errorf("invalid source must be error: %+v", s)
+		}
 	}
 }
 
-func TestParseDocExplainCh(t *testing.T) {
-	for _, testcase := range []struct {
-		in, out string
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		old, new, selector string
 	}{
-		{"./y28_spring_q2.html", "./y28_spring_q2.txt"},
-		{"./y19_spring_q26.html", "./y19_spring_q26.txt"},
+		{`<h3 class="qno">問2</h3>`, ``, selectorQuestion},
+		{`<li><a class="selectBtn"><button>エ</button></a><div>選択肢D</div></li>`, ``, selectorSelections},
+		{`<span id="answerChar">イ</span>`, ``, selectorAnswer},
+		{`<span id="answerChar">イ</span>`, `<span id="answerChar">-</span>`, selectorAnswer},
 	} {
-		doc, err := goqueryDocFile(testcase.in)
+		page := strings.Replace(structuredPage, c.old, c.new, 1)
+		_, err := parseHTML("http://example.com/q2.html", shiftJIS(t, page), ParserFunc(parseDoc), japanese.ShiftJIS)
+		var perr *ParseError
+		if !errors.Is(err, ErrParse) || !errors.As(err, &perr) {
+			t.Errorf("%s: broken page must be ParseError, got: %v", c.selector, err)
+			continue
+		}
+		if perr.Selector != c.selector || perr.URL != "http://example.com/q2.html" {
+			t.Errorf("invalid ParseError, got: %+v, want selector: %s", perr, c.selector)
+		}
+	}
+
+	// GetRandom tries the other question.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/q1.html") {
+			writeShiftJIS(t, w, "<html><body>layout changed</body></html>")
+			return
+		}
+		writeShiftJIS(t, w, structuredPage)
+	}))
+	defer ts.Close()
+
+	s := FE
+	s.URL = ts.URL + "/{{sikenYear .}}_{{.Season}}/q{{.No}}.html"
+	limiter := WithRateLimiter(NewRateLimiter(time.Millisecond, 1))
+	qr := QueryRange{MinYear: 28, MaxYear: 28, MinNo: 1, MaxNo: 2, Season: SeasonSpring}
+	g := NewGetter(s, LeastIntervalTime, limiter, WithParseRetry(1))
+	for i := 0; i < 10; i++ {
+		if _, err := g.GetRandom(context.Background(), qr); err != nil {
+			t.Fatalf("GetRandom must retry the other question, got: %v", err)
+		}
+	}
+	g = NewGetter(s, LeastIntervalTime, limiter)
+	qr.MaxNo = 1
+	if _, err := g.GetRandom(context.Background(), qr); !errors.Is(err, ErrParse) {
+		t.Errorf("GetRandom without retry must be ErrParse, got: %v", err)
+	}
+}
+
+// convert to ShiftJIS as served by the source server.
+func shiftJIS(t *testing.T, s string) []byte {
+	b, _, err := transform.Bytes(japanese.ShiftJIS.NewEncoder(), []byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// it writes s in ShiftJIS as served by the source server.
+func writeShiftJIS(t *testing.T, w http.ResponseWriter, s string) {
+	w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
+	w.Write(shiftJIS(t, s))
+}
+
+func TestDetectEncoding(t *testing.T) {
+	encode := func(enc encoding.Encoding, s string) []byte {
+		b, _, err := transform.Bytes(enc.NewEncoder(), []byte(s))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		res, err := parseDoc(doc)
+		return b
+	}
+	const text = "<html><body><p>基本情報技術者試験 ｱｲｳ</p></body></html>"
+	eucMeta := strings.Replace(text, "<body>", `<head><meta http-equiv="Content-Type" content="text/html; charset=EUC-JP"></head><body>`, 1)
+	for _, c := range []struct {
+		body        []byte
+		contentType string
+		override    string
+	}{
+		{encode(japanese.ShiftJIS, text), "text/html; charset=Shift_JIS", ""},
+		{encode(japanese.EUCJP, eucMeta), "text/html", ""},
+		{encode(japanese.ShiftJIS, text), "", ""},
+		{encode(japanese.EUCJP, text), "", ""},
+		{[]byte(text), "", ""},
+		{[]byte(text), "text/html; charset=utf-8", ""},
+		// the override is preferred to the wrong declaration.
+		{encode(japanese.EUCJP, text), "text/html; charset=Shift_JIS", "euc-jp"},
+	} {
+		enc, err := detectEncoding(c.body, c.contentType, c.override)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		fpout, err := os.Create(testcase.out)
+		doc, err := newDocument(c.body, enc)
 		if err != nil {
 			t.Fatal(err)
 		}
-		fmt.Fprintf(fpout, "Question:\n%v\nSelections:\n%v\nAnswer:\n%v\nExplanation:\n%v\n",
-			res.Question, res.Selections, res.Answer, res.Explanation)
-		fpout.Close()
+		if got := doc.Find("p").Text(); got != "基本情報技術者試験 ｱｲｳ" {
+			t.Errorf("%q, %q: invalid decoding, got: %s", c.contentType, c.override, got)
+		}
+	}
+	if _, err := detectEncoding(nil, "", "unknown"); err == nil {
+		t.Error("unknown encoding must be error")
 	}
 }
 
@@ -153,11 +1191,19 @@ func goqueryDocFile(file string) (*goquery.Document, error) {
 	}
 	defer fp.Close()
 
-	return goquery.NewDocumentFromReader(fp)
+	body, err := ioutil.ReadAll(fp)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := detectEncoding(body, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return newDocument(body, enc)
 }
 
 func BenchmarkParseDoc(b *testing.B) {
-	doc, err := goqueryDocFile("./y28_spring_q2.html")
+	doc, err := goqueryDocFile(filepath.Join("testdata", "golden", "fe", "table.html"))
 	if err != nil {
 		b.Fatal(err)
 	}
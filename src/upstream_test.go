@@ -0,0 +1,119 @@
+package src
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeUpstream is the fake source server for the tests, which serves the
+// questions in the URL shapes of fe-siken.com, e.g. /kakomon/28_haru/q2.html.
+// The fixture page in testdata/upstream is served if exists, otherwise
+// the page in the layout of fe-siken.com is generated for the question.
+// The pages are served in ShiftJIS as the source server does.
+type fakeUpstream struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	requests []string
+}
+
+var upstreamPath = regexp.MustCompile(`^/kakomon/(r?\d{2}_[a-z]+)/q(\d+)\.html$`)
+
+// it starts the fake source server, which is closed at the end of the test.
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	u := &fakeUpstream{t: t}
+	u.Server = httptest.NewServer(u)
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.requests = append(u.requests, r.URL.Path)
+	u.mu.Unlock()
+
+	m := upstreamPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	fixture := filepath.Join("testdata", "upstream", filepath.FromSlash(r.URL.Path))
+	data, err := ioutil.ReadFile(fixture)
+	switch {
+	case err == nil:
+		writeShiftJIS(u.t, w, string(data))
+	case os.IsNotExist(err):
+		no, _ := strconv.Atoi(m[2])
+		writeShiftJIS(u.t, w, upstreamPage(m[1], no))
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// it returns the paths requested so far.
+func (u *fakeUpstream) paths() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.requests...)
+}
+
+// it returns the source s located at the fake server.
+func (u *fakeUpstream) source(s Source) Source {
+	return sourceAt(s, u.URL)
+}
+
+// upstreamURL is the stable location of the fake server in the cassettes,
+// which never resolves.
+const upstreamURL = "http://fe-siken.upstream.test"
+
+// it returns the transport which sends the requests for any host to the fake server,
+// so that the cassettes are recorded at upstreamURL rather than the random port.
+func (u *fakeUpstream) transport() http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Host = strings.TrimPrefix(u.URL, "http://")
+		return http.DefaultTransport.RoundTrip(req)
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// it returns the source s located at base such as http://localhost:8080.
+func sourceAt(s Source, base string) Source {
+	if i := strings.Index(s.URL, "://"); i >= 0 {
+		if j := strings.Index(s.URL[i+3:], "/"); j >= 0 {
+			s.URL = base + s.URL[i+3+j:]
+		}
+	}
+	return s
+}
+
+// it returns the generated page of the question no in the session dir.
+// The answer is rotated by the number.
+func upstreamPage(dir string, no int) string {
+	return fmt.Sprintf(`<html><body><div class="main kako">
+<h3 class="qno">問%[2]d</h3>
+<div>%[1]s 問%[2]dの問題文。</div>
+<div class="ansbg"><ul class="selectList cf">
+<li><a class="selectBtn"><button>ア</button></a><div>選択肢A</div></li>
+<li><a class="selectBtn"><button>イ</button></a><div>選択肢B</div></li>
+<li><a class="selectBtn"><button>ウ</button></a><div>選択肢C</div></li>
+<li><a class="selectBtn"><button>エ</button></a><div>選択肢D</div></li>
+</ul></div>
+<div class="answerBox"><span id="answerChar">%[3]s</span></div>
+<h3>解説</h3>
+<div class="ansbg">%[1]s 問%[2]dの解説。</div>
+</div></body></html>`, dir, no, choiceLabels[no%len(choiceLabels)])
+}
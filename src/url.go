@@ -9,6 +9,7 @@ import (
 )
 
 type urlGenerator struct {
+	mu   sync.RWMutex // guards src, which is updated by setSessions.
 	src  Source
 	tmpl *template.Template
 }
@@ -19,17 +20,55 @@ func newURLGenerator(s Source) *urlGenerator {
 	}
 	return &urlGenerator{
 		src:  s,
-		tmpl: template.Must(template.New(s.URL).Parse(s.URL)),
+		tmpl: template.Must(template.New(s.URL).Funcs(urlFuncs).Parse(s.URL)),
 	}
 }
 
+// it returns the current source, whose MaxYear is resolved to the current year if zero.
+func (url *urlGenerator) source() Source {
+	url.mu.RLock()
+	defer url.mu.RUnlock()
+	return url.src.resolved()
+}
+
+// it replaces the catalogue of the source, and extends the range
+// of the source to cover the catalogue.
+func (url *urlGenerator) setSessions(ss []Session) error {
+	url.mu.Lock()
+	defer url.mu.Unlock()
+	s := url.src
+	s.Sessions = ss
+	for i, session := range ss {
+		y := session.query(s.MinNo).WesternYear()
+		if i == 0 || y < westernYear(s.MinYear) {
+			s.MinYear = y
+		}
+		if i == 0 || y > westernYear(s.MaxYear) {
+			s.MaxYear = y
+		}
+		if session.MaxNo > s.MaxNo {
+			s.MaxNo = session.MaxNo
+		}
+	}
+	if err := s.ValidatesSelf(); err != nil {
+		return err
+	}
+	url.src = s
+	return nil
+}
+
 // generate source URL with given query.
 func (url *urlGenerator) Generate(q Query) (string, error) {
-	if err := url.src.Validates(q); err != nil {
+	if err := url.source().Validates(q); err != nil {
 		return "", err
 	}
+	return url.render(q)
+}
+
+// it renders the URL template with the query without validation.
+func (url *urlGenerator) render(q Query) (string, error) {
 	buf := new(bytes.Buffer)
-	if err := url.tmpl.Execute(buf, &q); err != nil {
+	if err := url.tmpl.Execute(buf, q); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
@@ -37,26 +76,38 @@ func (url *urlGenerator) Generate(q Query) (string, error) {
 
 // generate randomized source URL with query range.
 func (url *urlGenerator) Random(qr QueryRange) (string, error) {
+	q, err := url.RandomQuery(qr)
+	if err != nil {
+		return "", err
+	}
+	return url.Generate(q)
+}
+
+// generate randomized query with query range.
+func (url *urlGenerator) RandomQuery(qr QueryRange) (Query, error) {
 	if qr == MaxQueryRange {
 		qr = url.MaxQueryRange()
 	}
-	if err := url.src.ValidatesRange(qr); err != nil {
-		return "", err
+	src := url.source()
+	if err := src.ValidatesRange(qr); err != nil {
+		return Query{}, err
 	}
-	return url.Generate(randomQuery(qr))
+	return src.randomQuery(qr, time.Now())
 }
 
 // return maximum range of query for the url's source.
 func (url *urlGenerator) MaxQueryRange() QueryRange {
-	return url.src.QueryRange
+	return url.source().QueryRange
 }
 
 // These represents the minimum and maximum query range
 // for the F.E. examination.
 const (
-	// Examination Year range
-	MinYear = 13
-	MaxYear = 29
+	// Examination Year range, in the Western calendar.
+	// the years were Heisei years, 13 and 29, before the Reiwa era.
+	// The later sessions can be served by Source.Sessions or IndexURL.
+	MinYear = 2001
+	MaxYear = 2022
 
 	// Examination Season selections
 	SeasonSpring = "haru"
@@ -69,70 +120,81 @@ const (
 )
 
 var (
-	yearRange   = [...]int{MinYear, MaxYear}
 	seasonRange = [...]string{SeasonSpring, SeasonAutumn}
 	noRange     = [...]int{MinNo, MaxNo}
 )
 
 // Query is a query for source URL.
 // Its fields specifies which question is searched for.
+// The session of the examination is specified by Era, Year and Season,
+// e.g. {EraReiwa, 1, SeasonAutumn} for the autumn session of 2019.
 type Query struct {
-	Year   int
-	Season string
+	Era    string // EraHeisei | EraReiwa. empty means EraHeisei.
+	Year   int    // year in the era.
+	Season string // SeasonSpring | SeasonAutumn | special session such as SeasonOctober.
 	No     int
 }
 
 // MaxQueryRange indicates the maximum range of query in the source.
-var MaxQueryRange = QueryRange{-1, -1, -1, -1, SeasonAll}
+var MaxQueryRange = QueryRange{MaxYear: -1, MinYear: -1, MaxNo: -1, MinNo: -1, Season: SeasonAll}
 
 // QueryRange represents query range for randomly selected.
+// The years are Western years, or Heisei years if they are less than
+// FirstWesternYear for the compatibility.
 type QueryRange struct {
 	MaxYear, MinYear int
 	MaxNo, MinNo     int
 	Season           string // SeasonSpring | SeasonAutumn | SeasonAll
+	Category         string // name of Category or its Field. empty means all.
 }
 
-func (qr QueryRange) season(r *rand.Rand) string {
-	switch s := qr.Season; s {
-	case SeasonSpring, SeasonAutumn:
-		return s
-	case SeasonAll:
-		return seasonRange[r.Intn(len(seasonRange))]
-	default:
-		panic("src.QueryRange: unknown season " + s)
-		return ""
+// it returns MaxYear in the Western calendar.
+// zero MaxYear means the current year.
+func (qr QueryRange) maxYear() int {
+	if qr.MaxYear == 0 {
+		return time.Now().Year()
 	}
+	return westernYear(qr.MaxYear)
 }
 
-var randMutex = new(sync.Mutex)
+// it returns the regular seasons in the range.
+func (qr QueryRange) seasons() []string {
+	if qr.Season == SeasonAll {
+		return seasonRange[:]
+	}
+	return []string{qr.Season}
+}
 
-// package global random state. under mutex.
-var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+// Contains returns whether the query is in the range.
+// MaxQueryRange is not supported, use the actual range insteadly.
+func (qr QueryRange) Contains(q Query) bool {
+	if y := q.WesternYear(); y < westernYear(qr.MinYear) || y > qr.maxYear() {
+		return false
+	}
+	if q.No < qr.MinNo || q.No > qr.MaxNo {
+		return false
+	}
+	return qr.Season == SeasonAll || qr.Season == regularSeason(q.Season)
+}
 
-// generates random query.
-func randomQuery(qrange QueryRange) Query {
-	beforeAutumn := !autumnPublished()
-	randMutex.Lock()
-	// autumn examination in the latest year is unpublisded,
-	// shirnk the max year range.
-	season := qrange.season(random)
-	if beforeAutumn && season == SeasonAutumn && qrange.MaxYear == MaxYear {
-		qrange.MaxYear -= 1
+// it returns the sessions in the range published at now,
+// as the queries without No.
+func (qr QueryRange) sessions(now time.Time) []Query {
+	var qs []Query
+	for year := westernYear(qr.MinYear); year <= qr.maxYear(); year++ {
+		for _, season := range qr.seasons() {
+			if q, ok := WesternQuery(year, season, 0); ok && q.published(now) {
+				qs = append(qs, q)
+			}
+		}
 	}
-	year := random.Intn(qrange.MaxYear-qrange.MinYear+1) + qrange.MinYear
-	no := random.Intn(qrange.MaxNo-qrange.MinNo+1) + qrange.MinNo
-	randMutex.Unlock()
-	return Query{year, season, no}
+	return qs
 }
 
-// indicates the day in which the autumn examination is published.
-const autumnPublishedMonth = time.November
+var randMutex = new(sync.Mutex)
 
-func autumnPublished() bool {
-	now := time.Now()
-	publishedDay := time.Date(now.Year(), autumnPublishedMonth, 0, 0, 0, 0, 0, time.UTC)
-	return now.After(publishedDay)
-}
+// package global random state. under mutex.
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 var _FE_URL = newURLGenerator(FE)
 
@@ -36,6 +36,9 @@ func writeStatistics(writer io.Writer) {
 
 	for y := src.MinYear; y <= src.MaxYear; y++ {
 		for _, s := range []string{src.SeasonSpring, src.SeasonAutumn} {
+			if _, ok := src.WesternQuery(y, s, src.MinNo); !ok {
+				continue
+			}
 			count, techC, manaC, stratC, errCount := countHasImage(y, s)
 
 			totalCount += count
@@ -64,22 +67,13 @@ func rate(a int) float64 {
 	return float64(a) / float64(src.MaxNo)
 }
 
-const (
-	TechMax  = 50
-	ManaMax  = 60
-	StratMax = 80
-)
-
 var getter = src.NewGetter(src.FE, src.LeastIntervalTime)
 
 func countHasImage(year int, season string) (count, techC, manaC, stratC, errCount int) {
 	ctx := context.Background()
 	for q := src.MinNo; q <= src.MaxNo; q++ {
-		res, err := getter.Get(ctx, src.Query{
-			Year:   year,
-			Season: season,
-			No:     q,
-		})
+		query, _ := src.WesternQuery(year, season, q)
+		res, err := getter.Get(ctx, query)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			errCount += 1
@@ -87,13 +81,13 @@ func countHasImage(year int, season string) (count, techC, manaC, stratC, errCou
 		}
 		if res.HasImage {
 			count++
-			switch {
-			case q <= TechMax:
+			switch res.Category {
+			case src.CategoryTechnology:
 				techC += 1
-			case q <= ManaMax:
-				manaC += 1
-			case q <= StratMax:
+			case src.CategoryManagement:
 				manaC += 1
+			case src.CategoryStrategy:
+				stratC += 1
 			}
 		}
 	}